@@ -0,0 +1,125 @@
+package gocaptcha
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scriptAsset is the embedded gocaptcha.js with everything needed to serve it
+// efficiently, computed once at startup. There is no brotli variant on
+// purpose: the standard library has no brotli encoder, and gzip of a small
+// script is within a few hundred bytes of it, not worth a dependency.
+type scriptAsset struct {
+	data    []byte
+	gz      []byte    // gzip variant, nil if not smaller
+	hash    string    // hex content hash used in the ETag and versioned file name
+	modTime time.Time // Last-Modified: embedded files carry no time, so the process start
+}
+
+var embeddedScript = func() scriptAsset {
+	data, err := embeddedJS.ReadFile("static/js/gocaptcha.js")
+	if err != nil {
+		return scriptAsset{}
+	}
+	sum := sha256.Sum256(data)
+	a := scriptAsset{data: data, hash: hex.EncodeToString(sum[:6]), modTime: time.Now().UTC().Truncate(time.Second)}
+	var buf bytes.Buffer
+	if zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression); err == nil {
+		_, _ = zw.Write(data)
+		if zw.Close() == nil && buf.Len() < len(data) {
+			a.gz = buf.Bytes()
+		}
+	}
+	return a
+}()
+
+// ScriptFile returns the versioned file name of the embedded script,
+// "gocaptcha.<hash>.js". JSHandler and Handler serve it with long-lived
+// immutable caching.
+func ScriptFile() string {
+	return "gocaptcha." + embeddedScript.hash + ".js"
+}
+
+// ScriptURL returns the versioned URL of the script: Config.ScriptPath with
+// its gocaptcha.js file name replaced by ScriptFile(). Other paths are
+// returned unchanged. The URL only resolves when ScriptPath is served by
+// JSHandler or Handler.
+func (c *Captcha) ScriptURL() string {
+	p := c.cfg.ScriptPath
+	if path.Base(p) != "gocaptcha.js" {
+		return p
+	}
+	return strings.TrimSuffix(p, "gocaptcha.js") + ScriptFile()
+}
+
+// scriptSrc is the script URL written by the template helpers and InjectHTML.
+// With RotateFields only Handler can serve the script.
+func (c *Captcha) scriptSrc() string {
+	if c.cfg.RotateFields {
+		return c.endpoint("gocaptcha.js")
+	}
+	if c.cfg.VersionedScript {
+		return c.ScriptURL()
+	}
+	return c.cfg.ScriptPath
+}
+
+// isScriptName reports whether name is gocaptcha.js or the versioned name of
+// this build (ScriptFile). Versioned names with another hash are 404s, so a
+// stale or made-up URL is never cached as immutable.
+func isScriptName(name string) bool {
+	return name == "gocaptcha.js" || name == ScriptFile()
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		enc := strings.ToLower(strings.TrimSpace(fields[0]))
+		if enc != "gzip" && enc != "*" {
+			continue
+		}
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if q, err := strconv.ParseFloat(f[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serveScript writes the embedded script for a request whose file name is
+// name: the versioned name gets a one-year immutable Cache-Control, anything
+// else is revalidated with the ETag on every use.
+func serveScript(w http.ResponseWriter, r *http.Request, name string) {
+	a := embeddedScript
+	h := w.Header()
+	h.Set("Content-Type", "text/javascript; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Vary", "Accept-Encoding")
+	if name == ScriptFile() {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	body, etag := a.data, `"`+a.hash+`"`
+	if a.gz != nil && acceptsGzip(r) {
+		body, etag = a.gz, `"`+a.hash+`-gz"`
+		h.Set("Content-Encoding", "gzip")
+	}
+	h.Set("ETag", etag)
+	// ServeContent sets Last-Modified and handles If-None-Match and
+	// If-Modified-Since (304), HEAD and Range requests
+	http.ServeContent(w, r, "", a.modTime, bytes.NewReader(body))
+}
@@ -0,0 +1,44 @@
+package gocaptcha
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSHandlerNames(t *testing.T) {
+	h := JSHandler()
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/gocaptcha.js", http.StatusOK},
+		{"/" + ScriptFile(), http.StatusOK},
+		{"/gocaptcha.000000000000.js", http.StatusNotFound},
+		{"/gocaptcha.evil.js", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestJSHandlerLastModified(t *testing.T) {
+	h := JSHandler()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/gocaptcha.js", nil))
+	lm := rec.Header().Get("Last-Modified")
+	if lm == "" {
+		t.Fatal("no Last-Modified header")
+	}
+	r := httptest.NewRequest("GET", "/gocaptcha.js", nil)
+	r.Header.Set("If-Modified-Since", lm)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since = %d, want 304", rec.Code)
+	}
+}
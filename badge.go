@@ -0,0 +1,205 @@
+package gocaptcha
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BadgeOptions controls how BadgeHTML renders the badge.
+type BadgeOptions struct {
+	// Position is "bottom-right" (default), "bottom-left", "top-right",
+	// "top-left" or "inline" (rendered in place, not fixed).
+	Position string
+	// Theme is "dark" (default), "light" or "auto" (follows prefers-color-scheme).
+	Theme string
+	// Size is "small", "medium" (default) or "large".
+	Size string
+	// PrivacyURL adds a link to your privacy policy. PrivacyText is its label
+	// (defaults to a localized "Privacy").
+	PrivacyURL  string
+	PrivacyText string
+	// Messages holds the badge text per language tag ("de", "pt-br"), chosen by
+	// Accept-Language in BadgeHTMLFor. Config.BadgeMessage is the fallback.
+	Messages map[string]string
+	// ClassMode renders only class names, without the inline <style> element,
+	// for pages with a strict Content-Security-Policy. Include BadgeCSS() in
+	// your stylesheet or link Handler's badge.css. Config.ExternalStyles
+	// implies it.
+	ClassMode bool
+}
+
+// badgeTexts are the built-in translations used when neither BadgeMessage nor
+// BadgeOptions.Messages is set: message and privacy link label.
+var badgeTexts = map[string][2]string{
+	"en": {"Protected by GoCaptcha", "Privacy"},
+	"de": {"Geschützt durch GoCaptcha", "Datenschutz"},
+	"fr": {"Protégé par GoCaptcha", "Confidentialité"},
+	"es": {"Protegido por GoCaptcha", "Privacidad"},
+	"it": {"Protetto da GoCaptcha", "Privacy"},
+	"pt": {"Protegido por GoCaptcha", "Privacidade"},
+	"nl": {"Beschermd door GoCaptcha", "Privacy"},
+	"pl": {"Chronione przez GoCaptcha", "Prywatność"},
+	"sr": {"Zaštićeno pomoću GoCaptcha", "Privatnost"},
+	"ru": {"Защищено GoCaptcha", "Конфиденциальность"},
+}
+
+const badgeCSS = `.gocaptcha-badge{z-index:2147483647;display:inline-flex;align-items:center;gap:6px;padding:6px 10px;border-radius:999px;box-shadow:0 2px 10px rgba(0,0,0,.2);font:12px/1 system-ui,-apple-system,Segoe UI,Roboto,Arial,Helvetica,sans-serif}
+.gocaptcha-badge svg{width:1.15em;height:1.15em;flex:none}
+.gocaptcha-badge a{color:inherit;opacity:.8;text-decoration:underline}
+.gocaptcha-badge--bottom-right{position:fixed;right:12px;bottom:12px}
+.gocaptcha-badge--bottom-left{position:fixed;left:12px;bottom:12px}
+.gocaptcha-badge--top-right{position:fixed;right:12px;top:12px}
+.gocaptcha-badge--top-left{position:fixed;left:12px;top:12px}
+.gocaptcha-badge--inline{position:static}
+.gocaptcha-badge--small{padding:4px 8px;font-size:11px}
+.gocaptcha-badge--large{padding:8px 14px;font-size:14px}
+.gocaptcha-badge--dark{background:rgba(17,17,17,.72);color:#fff;backdrop-filter:saturate(150%) blur(6px)}
+.gocaptcha-badge--light,.gocaptcha-badge--auto{background:rgba(255,255,255,.9);color:#111;border:1px solid rgba(0,0,0,.12)}
+@media (prefers-color-scheme:dark){.gocaptcha-badge--auto{background:rgba(17,17,17,.72);color:#fff;border-color:transparent}}
+`
+
+// BadgeCSS returns the stylesheet used by the badge, for BadgeOptions.ClassMode.
+func BadgeCSS() string {
+	return badgeCSS
+}
+
+var badgeTmpl = template.Must(template.New("badge").Parse(
+	`{{if .CSS}}<style{{if .Nonce}} nonce="{{.Nonce}}"{{end}}>{{.CSS}}</style>{{end}}` +
+		`<div class="gocaptcha-badge gocaptcha-badge--{{.Position}} gocaptcha-badge--{{.Theme}} gocaptcha-badge--{{.Size}}" role="note"{{if .Lang}} lang="{{.Lang}}"{{end}}>` +
+		`<svg xmlns="http://www.w3.org/2000/svg" width="14" height="14" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round" aria-hidden="true"><rect x="3" y="11" width="18" height="10" rx="2" ry="2"/><path d="M7 11V7a5 5 0 0 1 10 0v4"/></svg>` +
+		`<span>{{.Message}}</span>` +
+		`{{if .PrivacyURL}}<a href="{{.PrivacyURL}}" target="_blank" rel="noopener">{{.PrivacyText}}</a>{{end}}` +
+		`</div>`))
+
+// oneOf returns v if it is one of allowed, else allowed[0].
+func oneOf(v string, allowed ...string) string {
+	v = strings.ToLower(strings.TrimSpace(v))
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	return allowed[0]
+}
+
+// BadgeHTMLFor renders the badge like BadgeHTML, localized for the request's
+// Accept-Language header. The <style> element carries the nonce from
+// Config.CSPNonce.
+func (c *Captcha) BadgeHTMLFor(r *http.Request) string {
+	return c.badgeHTML(r, c.cspNonce(r))
+}
+
+func (c *Captcha) badgeHTML(r *http.Request, nonce string) string {
+	if !c.cfg.ShowBadge {
+		return ""
+	}
+	accept := ""
+	if r != nil {
+		accept = r.Header.Get("Accept-Language")
+	}
+	return c.renderBadge(acceptLanguages(accept), nonce)
+}
+
+func (c *Captcha) renderBadge(langs []string, nonce string) string {
+	o := c.cfg.Badge
+	lang, msg := c.badgeMessage(langs)
+	privacy := o.PrivacyText
+	if privacy == "" {
+		privacy = badgeTexts[baseLang(lang)][1]
+		if privacy == "" {
+			privacy = badgeTexts["en"][1]
+		}
+	}
+	data := struct {
+		CSS                              template.CSS
+		Position, Theme, Size, Lang      string
+		Message, PrivacyURL, PrivacyText string
+		Nonce                            string
+	}{
+		Position:    oneOf(o.Position, "bottom-right", "bottom-left", "top-right", "top-left", "inline"),
+		Theme:       oneOf(o.Theme, "dark", "light", "auto"),
+		Size:        oneOf(o.Size, "medium", "small", "large"),
+		Lang:        lang,
+		Message:     msg,
+		PrivacyURL:  o.PrivacyURL,
+		PrivacyText: privacy,
+		Nonce:       nonce,
+	}
+	if !o.ClassMode && !c.cfg.ExternalStyles {
+		data.CSS = template.CSS(badgeCSS)
+	}
+	var sb strings.Builder
+	if err := badgeTmpl.Execute(&sb, data); err != nil {
+		return ""
+	}
+	return sb.String()
+}
+
+// badgeMessage picks the badge text for the preferred languages: configured
+// Messages first, then BadgeMessage, then the built-in translations.
+func (c *Captcha) badgeMessage(langs []string) (lang, msg string) {
+	msgs := c.cfg.Badge.Messages
+	for _, l := range langs {
+		for _, k := range []string{l, baseLang(l)} {
+			for key, m := range msgs {
+				if strings.EqualFold(key, k) && strings.TrimSpace(m) != "" {
+					return k, m
+				}
+			}
+		}
+	}
+	if m := strings.TrimSpace(c.cfg.BadgeMessage); m != "" {
+		return "", m
+	}
+	for _, l := range langs {
+		if t, ok := badgeTexts[baseLang(l)]; ok {
+			return baseLang(l), t[0]
+		}
+	}
+	return "", badgeTexts["en"][0]
+}
+
+func baseLang(tag string) string {
+	if i := strings.IndexAny(tag, "-_"); i > 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+// acceptLanguages returns the lowercase language tags of an Accept-Language
+// header ordered by preference. "*" and tags with q=0 are dropped.
+func acceptLanguages(h string) []string {
+	type tagQ struct {
+		tag string
+		q   float64
+	}
+	var tags []tagQ
+	for _, part := range strings.Split(h, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tagQ{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	out := make([]string, len(tags))
+	for i, t := range tags {
+		out[i] = t.tag
+	}
+	return out
+}
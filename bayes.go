@@ -0,0 +1,202 @@
+package gocaptcha
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bayesClassifier is a token-based naive Bayes spam classifier. Counts are
+// kept in memory and, when storage is enabled, written through to SQLite.
+//
+// Token counts are per document (a token seen twice in one message counts
+// once), so P(token|class) = (docs of class containing token + 1) / (docs of class + 2).
+type bayesClassifier struct {
+	mu     sync.RWMutex
+	tokens map[string]*[2]int // token -> [ham, spam] document counts
+	docs   [2]int             // [ham, spam] trained documents
+}
+
+const (
+	bayesHam  = 0
+	bayesSpam = 1
+
+	bayesMaxTokens = 200 // tokens considered per document
+)
+
+func newBayesClassifier() *bayesClassifier {
+	return &bayesClassifier{tokens: make(map[string]*[2]int)}
+}
+
+// initBayesStorage creates the classifier tables and loads existing counts.
+func (c *Captcha) initBayesStorage() {
+	c.db.Exec(`CREATE TABLE IF NOT EXISTS bayes_tokens (token TEXT PRIMARY KEY, ham INTEGER NOT NULL DEFAULT 0, spam INTEGER NOT NULL DEFAULT 0)`)
+	c.db.Exec(`CREATE TABLE IF NOT EXISTS bayes_totals (label TEXT PRIMARY KEY, docs INTEGER NOT NULL DEFAULT 0)`)
+
+	b := c.bayes
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if rows, err := c.db.Query(`SELECT token, ham, spam FROM bayes_tokens`); err == nil {
+		for rows.Next() {
+			var tok string
+			var ham, spam int
+			if err := rows.Scan(&tok, &ham, &spam); err == nil {
+				b.tokens[tok] = &[2]int{ham, spam}
+			}
+		}
+		rows.Close()
+	}
+	if rows, err := c.db.Query(`SELECT label, docs FROM bayes_totals`); err == nil {
+		for rows.Next() {
+			var label string
+			var docs int
+			if err := rows.Scan(&label, &docs); err == nil {
+				switch label {
+				case "ham":
+					b.docs[bayesHam] = docs
+				case "spam":
+					b.docs[bayesSpam] = docs
+				}
+			}
+		}
+		rows.Close()
+	}
+}
+
+// Train adds a labeled example to the content classifier. Feed it messages you
+// have confirmed as spam (isSpam=true) or legitimate (isSpam=false); the
+// classifier only starts scoring once both classes have Config.BayesMinDocs examples.
+func (c *Captcha) Train(text string, isSpam bool) error {
+	toks := bayesTokens(Skeleton(text))
+	if len(toks) == 0 {
+		return nil
+	}
+	class, label := bayesHam, "ham"
+	if isSpam {
+		class, label = bayesSpam, "spam"
+	}
+
+	if c.db != nil {
+		tx, err := c.db.Begin()
+		if err != nil {
+			return err
+		}
+		col := label // fixed column name, never user input
+		for _, t := range toks {
+			if _, err := tx.Exec(`INSERT INTO bayes_tokens (token, `+col+`) VALUES (?, 1)
+				ON CONFLICT(token) DO UPDATE SET `+col+` = `+col+` + 1`, t); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO bayes_totals (label, docs) VALUES (?, 1)
+			ON CONFLICT(label) DO UPDATE SET docs = docs + 1`, label); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	b := c.bayes
+	b.mu.Lock()
+	for _, t := range toks {
+		cnt := b.tokens[t]
+		if cnt == nil {
+			cnt = &[2]int{}
+			b.tokens[t] = cnt
+		}
+		cnt[class]++
+	}
+	b.docs[class]++
+	b.mu.Unlock()
+	return nil
+}
+
+// Classify returns the probability (0..1) that text is spam according to the
+// trained classifier. It returns 0.5 when nothing has been trained yet.
+func (c *Captcha) Classify(text string) float64 {
+	return c.bayes.classify(bayesTokens(Skeleton(text)))
+}
+
+func (b *bayesClassifier) classify(toks []string) float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ham, spam := float64(b.docs[bayesHam]), float64(b.docs[bayesSpam])
+	if ham == 0 || spam == 0 {
+		return 0.5
+	}
+	logOdds := math.Log(spam / ham)
+	for _, t := range toks {
+		cnt := b.tokens[t]
+		if cnt == nil {
+			continue // unseen tokens carry no evidence
+		}
+		pSpam := (float64(cnt[bayesSpam]) + 1) / (spam + 2)
+		pHam := (float64(cnt[bayesHam]) + 1) / (ham + 2)
+		logOdds += math.Log(pSpam / pHam)
+	}
+	return sigmoid(logOdds)
+}
+
+// trained reports whether both classes have at least minDocs examples.
+func (b *bayesClassifier) trained(minDocs int) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.docs[bayesHam] >= minDocs && b.docs[bayesSpam] >= minDocs
+}
+
+// bayesTokens splits text into unique lowercase word tokens (2..24 runes).
+// Link hosts are added as "url:<host>" tokens so spammy domains are learned too.
+func bayesTokens(text string) []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(t string) {
+		if len(out) >= bayesMaxTokens || seen[t] {
+			return
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	for _, link := range urlRe.FindAllString(text, -1) {
+		host := strings.ToLower(link)
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "http://"), "https://")
+		host = strings.TrimPrefix(host, "www.")
+		if i := strings.IndexAny(host, "/?#:"); i >= 0 {
+			host = host[:i]
+		}
+		if host != "" {
+			add("url:" + host)
+		}
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if n := utf8.RuneCountInString(w); n < 2 || n > 24 {
+			continue
+		}
+		add(w)
+	}
+	return out
+}
+
+// scoreBayes converts the classifier's spam probability for msg into a penalty.
+func (c *Captcha) scoreBayes(msg string) (int, string) {
+	if c.cfg.BayesWeight < 0 || msg == "" || !c.bayes.trained(c.cfg.BayesMinDocs) {
+		return 0, ""
+	}
+	p := c.Classify(msg)
+	why := "bayes_spam:" + strconv.FormatFloat(p, 'f', 2, 64)
+	switch {
+	case p >= 0.9:
+		return -c.cfg.BayesWeight, why
+	case p >= 0.75:
+		return -(c.cfg.BayesWeight + 1) / 2, why
+	}
+	return 0, ""
+}
@@ -0,0 +1,140 @@
+package gocaptcha
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Header fallbacks for the captcha fields, for clients that cannot put them
+// in the body (e.g. fetch() calls to JSON APIs). Their names are static, so
+// they are not accepted with Config.RotateFields.
+var captchaHeaders = map[string]string{
+	"ts":            "X-GoCaptcha-TS",
+	"js_token":      "X-GoCaptcha-JS-Token",
+	"behavior_data": "X-GoCaptcha-Behavior",
+}
+
+// captchaValue returns a captcha field from the parsed form (which includes
+// JSON bodies) or, when absent there, from a fresh X-GoCaptcha-Token or its
+// individual X-GoCaptcha-* header. With Config.RotateFields the form field
+// has the epoch's name (see fieldSet) and the X-GoCaptcha-* headers are
+// ignored, or a forgery script could skip the rotated names.
+func (c *Captcha) captchaValue(r *http.Request, name string) string {
+	if v := r.FormValue(c.requestFields(r).name(name)); v != "" {
+		return v
+	}
+	if v := c.tokenValue(r, name); v != "" {
+		return v
+	}
+	if h := captchaHeaders[name]; h != "" && !c.cfg.RotateFields {
+		return strings.TrimSpace(r.Header.Get(h))
+	}
+	return ""
+}
+
+// bodyReadCloser replays the buffered part of a body before the unread rest,
+// and closes the original body.
+type bodyReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// parseBody parses the request body into r.Form for url-encoded, multipart and
+// JSON requests, reading at most Config.MaxBodyBytes. The body is restored
+// afterwards so the downstream handler can read it again. When the body is
+// larger than the limit it is left unparsed and "body_too_large" is returned;
+// evaluate blocks such requests.
+func (c *Captcha) parseBody(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", r.ParseForm()
+	}
+	orig := r.Body
+	buf, err := io.ReadAll(io.LimitReader(orig, c.cfg.MaxBodyBytes+1))
+	if err != nil {
+		return "", err
+	}
+	restore := func() {
+		r.Body = bodyReadCloser{io.MultiReader(bytes.NewReader(buf), orig), orig}
+	}
+	if int64(len(buf)) > c.cfg.MaxBodyBytes {
+		restore()
+		// Query parameters only
+		if r.Form == nil {
+			q, _ := url.ParseQuery(r.URL.RawQuery)
+			r.Form = q
+		}
+		return "body_too_large", nil
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(buf))
+	defer restore()
+
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case ct == "multipart/form-data":
+		return "", r.ParseMultipartForm(c.cfg.MaxBodyBytes)
+	case ct == "application/json" || strings.HasSuffix(ct, "+json"):
+		if err := r.ParseForm(); err != nil { // query parameters
+			return "", err
+		}
+		if len(bytes.TrimSpace(buf)) == 0 {
+			return "", nil
+		}
+		var doc interface{}
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.UseNumber() // keep millisecond timestamps exact
+		if err := dec.Decode(&doc); err != nil {
+			return "", err
+		}
+		if r.PostForm == nil {
+			r.PostForm = url.Values{}
+		}
+		if obj, ok := doc.(map[string]interface{}); ok {
+			// Captcha fields may be grouped in a sub-object: {"gocaptcha": {"ts": ..., "js_token": ...}}
+			if sub, ok := obj[c.cfg.JSONCaptchaKey].(map[string]interface{}); ok {
+				delete(obj, c.cfg.JSONCaptchaKey)
+				flattenJSON(r.PostForm, "", sub)
+			}
+		}
+		flattenJSON(r.PostForm, "", doc)
+		for k, vs := range r.PostForm {
+			r.Form[k] = append(r.Form[k], vs...)
+		}
+		return "", nil
+	default:
+		return "", r.ParseForm()
+	}
+}
+
+// flattenJSON adds the scalar values of v to dst. Nested object keys are
+// joined with dots ("contact.name"); array elements repeat their key.
+func flattenJSON(dst url.Values, prefix string, v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, sub := range t {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenJSON(dst, key, sub)
+		}
+	case []interface{}:
+		for _, sub := range t {
+			flattenJSON(dst, prefix, sub)
+		}
+	case string:
+		dst.Add(prefix, t)
+	case json.Number:
+		dst.Add(prefix, t.String())
+	case bool:
+		dst.Add(prefix, strconv.FormatBool(t))
+	case nil:
+		// null carries no content
+	}
+}
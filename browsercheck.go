@@ -0,0 +1,361 @@
+package gocaptcha
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"html/template"
+	"math/bits"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BrowserCheckCookie is the name of the cookie BrowserCheck sets after a
+// solved challenge. Only BrowserCheck accepts it; it is not a clearance.
+const BrowserCheckCookie = "gc_browser"
+
+// Query parameters carrying a solved browser-check challenge.
+const (
+	powParam  = "__gc_pow"
+	powAnswer = "__gc_n"
+)
+
+// GoodBot describes a crawler exempt from BrowserCheck: its User-Agent must
+// contain Name and its IP must reverse-resolve to one of Domains (and that
+// host name must resolve back to the IP).
+type GoodBot struct {
+	Name    string
+	Domains []string
+}
+
+// DefaultGoodBots are the crawlers exempt from BrowserCheck when
+// Config.GoodBots is nil.
+var DefaultGoodBots = []GoodBot{
+	{Name: "Googlebot", Domains: []string{"googlebot.com", "google.com", "googleusercontent.com"}},
+	{Name: "bingbot", Domains: []string{"search.msn.com"}},
+	{Name: "Applebot", Domains: []string{"applebot.apple.com"}},
+	{Name: "YandexBot", Domains: []string{"yandex.ru", "yandex.net", "yandex.com"}},
+	{Name: "Baiduspider", Domains: []string{"baidu.com", "baidu.jp"}},
+	{Name: "DuckDuckBot", Domains: []string{"duckduckgo.com"}},
+	{Name: "Slurp", Domains: []string{"crawl.yahoo.net"}},
+}
+
+// goodBotCache remembers DNS verification results per IP for an hour.
+type goodBotCache struct {
+	mu  sync.Mutex
+	ips map[string]goodBotEntry
+}
+
+type goodBotEntry struct {
+	ok  bool
+	exp time.Time
+}
+
+// BrowserCheck protects GET routes (pricing, search, ...) from scrapers. Clients
+// without a valid gc_browser cookie get a small "checking your browser" page
+// that solves a SHA-256 proof of work (Config.ChallengeDifficulty leading zero
+// bits) in JavaScript, then returns to the original URL with that cookie
+// (lifetime Config.BrowserCheckTTL). A proof of work is cheap for a scripted
+// client too, so the cookie only opens BrowserCheck routes: CheckRequest
+// gives it no credit, and a gc_clearance still requires a confident
+// CheckRequestWithClearance pass. A valid gc_clearance also opens these routes.
+//
+// Verified crawlers (Config.GoodBots) and requests matching SkipPaths/SkipIf
+// pass through, as do methods other than GET and HEAD.
+func (c *Captcha) BrowserCheck(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, _ := c.shouldBypass(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if ch := q.Get(powParam); ch != "" {
+			if c.verifyPoW(r, ch, q.Get(powAnswer)) == nil {
+				c.setClearance(w, r, BrowserCheckCookie, purposeBrowserCheck, c.cfg.BrowserCheckTTL)
+				q.Del(powParam)
+				q.Del(powAnswer)
+				http.Redirect(w, r, localTarget(r.URL.Path, q.Encode()), http.StatusSeeOther)
+				return
+			}
+		} else if c.hasPass(r, BrowserCheckCookie, purposeBrowserCheck) || c.hasPass(r, ClearanceCookie, purposeClearance) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c.isGoodBot(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.serveChallenge(w, r)
+	})
+}
+
+// localTarget returns the same-site redirect target for path and query.
+// The path is cleaned and leading slashes and backslashes collapsed, so
+// "//evil.example/x" cannot turn into a protocol-relative URL off-site.
+func localTarget(p, rawQuery string) string {
+	clean := "/" + strings.TrimLeft(path.Clean("/"+p), "/\\")
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	u := url.URL{Path: clean, RawQuery: rawQuery}
+	return u.RequestURI()
+}
+
+// hasPass reports whether the request carries a valid pass cookie.
+func (c *Captcha) hasPass(r *http.Request, name, purpose string) bool {
+	ck, err := r.Cookie(name)
+	return err == nil && c.verifyClearance(r, purpose, ck.Value) == nil
+}
+
+// issuePoW returns a challenge "exp.rand.bits.mac" bound to the client.
+func (c *Captcha) issuePoW(r *http.Request) string {
+	body := strconv.FormatInt(time.Now().Add(5*time.Minute).Unix(), 10) + "." + b64(randomBytes(9)) + "." +
+		strconv.Itoa(c.cfg.ChallengeDifficulty)
+	return body + "." + c.sign("pow", body+"|"+c.jsCookieBinding(r))
+}
+
+// verifyPoW checks the challenge signature, expiry and single use, and that
+// SHA-256(challenge ":" answer) starts with the required number of zero bits.
+func (c *Captcha) verifyPoW(r *http.Request, challenge, answer string) error {
+	i := strings.LastIndexByte(challenge, '.')
+	if i <= 0 || answer == "" || len(answer) > 12 {
+		return errBadSignature
+	}
+	body, mac := challenge[:i], challenge[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(c.sign("pow", body+"|"+c.jsCookieBinding(r)))) {
+		return errBadSignature
+	}
+	parts := strings.Split(body, ".")
+	if len(parts) != 3 {
+		return errBadSignature
+	}
+	exp, err1 := strconv.ParseInt(parts[0], 10, 64)
+	need, err2 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil {
+		return errBadSignature
+	}
+	if time.Now().Unix() > exp {
+		return errExpired
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+answer))) < need {
+		return errors.New("proof of work not solved")
+	}
+	if !c.nonces.spend(challenge, time.Now()) {
+		return errors.New("challenge already used")
+	}
+	return nil
+}
+
+func leadingZeroBits(sum [32]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// isGoodBot reports whether the request comes from a verified crawler:
+// the User-Agent names it, and reverse plus forward DNS confirm its domain.
+func (c *Captcha) isGoodBot(r *http.Request) bool {
+	bots := c.cfg.GoodBots
+	if bots == nil {
+		bots = DefaultGoodBots
+	}
+	ua := strings.ToLower(r.Header.Get("User-Agent"))
+	var domains []string
+	for _, b := range bots {
+		if b.Name != "" && strings.Contains(ua, strings.ToLower(b.Name)) {
+			domains = append(domains, b.Domains...)
+		}
+	}
+	if len(domains) == 0 {
+		return false
+	}
+	ip := c.clientIP(r)
+	key := ip + "|" + strings.Join(domains, ",")
+	now := time.Now()
+	c.goodBots.mu.Lock()
+	if e, ok := c.goodBots.ips[key]; ok && now.Before(e.exp) {
+		c.goodBots.mu.Unlock()
+		return e.ok
+	}
+	c.goodBots.mu.Unlock()
+
+	ok := verifyCrawlerDNS(ip, domains)
+	c.goodBots.mu.Lock()
+	if c.goodBots.ips == nil {
+		c.goodBots.ips = make(map[string]goodBotEntry)
+	}
+	for k, e := range c.goodBots.ips {
+		if now.After(e.exp) {
+			delete(c.goodBots.ips, k)
+		}
+	}
+	c.goodBots.ips[key] = goodBotEntry{ok: ok, exp: now.Add(time.Hour)}
+	c.goodBots.mu.Unlock()
+	return ok
+}
+
+// verifyCrawlerDNS does the reverse-then-forward DNS check search engines
+// document for verifying their crawlers.
+func verifyCrawlerDNS(ip string, domains []string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil {
+		return false
+	}
+	for _, name := range names {
+		host := strings.ToLower(strings.TrimSuffix(name, "."))
+		matched := false
+		for _, d := range domains {
+			d = strings.ToLower(d)
+			if host == d || strings.HasSuffix(host, "."+d) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if a.IP.Equal(parsed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var challengeTmpl = template.Must(template.New("challenge").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width,initial-scale=1">
+<meta name="robots" content="noindex,nofollow">
+<title>Checking your browser…</title>
+<style nonce="{{.Nonce}}">
+body{margin:0;min-height:100vh;display:flex;align-items:center;justify-content:center;font:16px/1.5 system-ui,-apple-system,Segoe UI,Roboto,Arial,sans-serif;background:#f6f7f9;color:#111}
+main{max-width:28rem;padding:2rem;text-align:center}
+.spin{width:32px;height:32px;margin:0 auto 1rem;border:3px solid #ccd;border-top-color:#333;border-radius:50%;animation:s 1s linear infinite}
+h1{font-size:1.25rem;margin:0 0 .5rem}
+@keyframes s{to{transform:rotate(360deg)}}
+@media (prefers-color-scheme:dark){body{background:#111;color:#eee}.spin{border-color:#444;border-top-color:#eee}}
+</style>
+</head>
+<body>
+<main>
+<div class="spin" aria-hidden="true"></div>
+<h1>Checking your browser…</h1>
+<p id="gc-msg">This takes a moment and happens only once.</p>
+<noscript><p>Please enable JavaScript to continue.</p></noscript>
+</main>
+<script nonce="{{.Nonce}}">
+(function () {
+    var challenge = {{.Challenge}}, need = {{.Bits}}, param = {{.Param}}, answerParam = {{.AnswerParam}};
+    var K = [], H = [], p = 2, c = 0, d;
+    function frac(x) { return (x - Math.floor(x)) * 4294967296 | 0; }
+    while (c < 64) {
+        for (d = 2; d * d <= p; d++) if (p % d === 0) break;
+        if (d * d > p) { if (c < 8) H[c] = frac(Math.pow(p, 1 / 2)); K[c++] = frac(Math.pow(p, 1 / 3)); }
+        p++;
+    }
+    // SHA-256 of an ASCII string, as eight 32-bit words
+    function sha256(s) {
+        var words = [], w = [], hash = H.slice(0), n = s.length, i, j;
+        for (i = 0; i < n; i++) words[i >> 2] |= (s.charCodeAt(i) & 255) << ((3 - i % 4) * 8);
+        words[n >> 2] |= 0x80 << ((3 - n % 4) * 8);
+        var total = (((n + 8) >> 6) + 1) * 16;
+        words[total - 1] = n * 8;
+        for (j = 0; j < total; j += 16) {
+            var a = hash[0], b = hash[1], c = hash[2], d = hash[3], e = hash[4], f = hash[5], g = hash[6], h = hash[7];
+            for (i = 0; i < 64; i++) {
+                if (i < 16) {
+                    w[i] = words[j + i] | 0;
+                } else {
+                    var x = w[i - 15], y = w[i - 2];
+                    w[i] = (w[i - 16] + ((x >>> 7 | x << 25) ^ (x >>> 18 | x << 14) ^ (x >>> 3)) + w[i - 7] +
+                        ((y >>> 17 | y << 15) ^ (y >>> 19 | y << 13) ^ (y >>> 10))) | 0;
+                }
+                var t1 = (h + ((e >>> 6 | e << 26) ^ (e >>> 11 | e << 21) ^ (e >>> 25 | e << 7)) +
+                    ((e & f) ^ (~e & g)) + K[i] + w[i]) | 0;
+                var t2 = (((a >>> 2 | a << 30) ^ (a >>> 13 | a << 19) ^ (a >>> 22 | a << 10)) +
+                    ((a & b) ^ (a & c) ^ (b & c))) | 0;
+                h = g; g = f; f = e; e = (d + t1) | 0;
+                d = c; c = b; b = a; a = (t1 + t2) | 0;
+            }
+            hash[0] = (hash[0] + a) | 0; hash[1] = (hash[1] + b) | 0; hash[2] = (hash[2] + c) | 0; hash[3] = (hash[3] + d) | 0;
+            hash[4] = (hash[4] + e) | 0; hash[5] = (hash[5] + f) | 0; hash[6] = (hash[6] + g) | 0; hash[7] = (hash[7] + h) | 0;
+        }
+        return hash;
+    }
+    function zeroBits(h) {
+        var z = 0;
+        for (var i = 0; i < 8; i++) {
+            var l = Math.clz32(h[i]);
+            z += l;
+            if (l < 32) break;
+        }
+        return z;
+    }
+    var nonce = 0;
+    function work() {
+        var stop = Date.now() + 50;
+        while (Date.now() < stop) {
+            if (zeroBits(sha256(challenge + ':' + nonce)) >= need) {
+                var u = new URL(location.href);
+                u.searchParams.set(param, challenge);
+                u.searchParams.set(answerParam, String(nonce));
+                location.replace(u.toString());
+                return;
+            }
+            nonce++;
+        }
+        setTimeout(work, 0);
+    }
+    setTimeout(work, 0);
+})();
+</script>
+</body>
+</html>
+`))
+
+// serveChallenge writes the interstitial page with its own strict CSP.
+func (c *Captcha) serveChallenge(w http.ResponseWriter, r *http.Request) {
+	nonce := b64(randomBytes(16))
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Robots-Tag", "noindex, nofollow")
+	h.Set("Content-Security-Policy", "default-src 'none'; script-src 'nonce-"+nonce+"'; style-src 'nonce-"+nonce+"'; base-uri 'none'; form-action 'none'")
+	w.WriteHeader(http.StatusForbidden)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = challengeTmpl.Execute(w, struct {
+		Nonce, Challenge, Param, AnswerParam string
+		Bits                                 int
+	}{nonce, c.issuePoW(r), powParam, powAnswer, c.cfg.ChallengeDifficulty})
+}
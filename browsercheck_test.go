@@ -0,0 +1,84 @@
+package gocaptcha
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBrowserCheckPassIsNotClearance(t *testing.T) {
+	c := New(Config{Secret: []byte("test"), Clearance: true, ChallengeDifficulty: 8})
+	h := c.BrowserCheck(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	newReq := func(method, target string) *http.Request {
+		r := httptest.NewRequest(method, target, strings.NewReader("message=hi"))
+		r.Header.Set("User-Agent", "curl/8.0")
+		return r
+	}
+
+	// Solve the challenge the way a scripted client would
+	ch := c.issuePoW(newReq("GET", "/pricing"))
+	n := 0
+	for leadingZeroBits(sha256.Sum256([]byte(ch+":"+strconv.Itoa(n)))) < 8 {
+		n++
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newReq("GET", "/pricing?"+powParam+"="+url.QueryEscape(ch)+"&"+powAnswer+"="+strconv.Itoa(n)))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("solved challenge: status %d, want 303", rec.Code)
+	}
+	var pass *http.Cookie
+	for _, ck := range rec.Result().Cookies() {
+		if ck.Name == ClearanceCookie {
+			t.Fatalf("solved challenge set %s", ClearanceCookie)
+		}
+		if ck.Name == BrowserCheckCookie {
+			pass = ck
+		}
+	}
+	if pass == nil {
+		t.Fatalf("solved challenge set no %s cookie", BrowserCheckCookie)
+	}
+
+	// The pass opens BrowserCheck routes...
+	r := newReq("GET", "/pricing")
+	r.AddCookie(pass)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("with pass: status %d, want 200", rec.Code)
+	}
+
+	// ...but earns nothing in CheckRequest, even under the clearance cookie name
+	for _, name := range []string{BrowserCheckCookie, ClearanceCookie} {
+		r = newReq("POST", "/comment")
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: name, Value: pass.Value})
+		if pen, why := c.checkClearance(r); pen > 0 {
+			t.Errorf("cookie %s: checkClearance = %d %q, want no bonus", name, pen, why)
+		}
+		if !c.CheckRequest(r) {
+			t.Errorf("cookie %s: scripted POST passed CheckRequest", name)
+		}
+	}
+}
+
+func TestLocalTarget(t *testing.T) {
+	tests := []struct{ path, query, want string }{
+		{"/page", "a=1", "/page?a=1"},
+		{"/dir/", "", "/dir/"},
+		{"//evil.example/x", "", "/evil.example/x"},
+		{"/\\evil.example/x", "", "/evil.example/x"},
+		{"/a/../../b", "", "/b"},
+		{"", "", "/"},
+		{"/a b", "", "/a%20b"},
+	}
+	for _, tt := range tests {
+		if got := localTarget(tt.path, tt.query); got != tt.want {
+			t.Errorf("localTarget(%q, %q) = %q, want %q", tt.path, tt.query, got, tt.want)
+		}
+	}
+}
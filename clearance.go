@@ -0,0 +1,106 @@
+package gocaptcha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClearanceCookie is the name of the clearance cookie.
+const ClearanceCookie = "gc_clearance"
+
+// HMAC purposes of the two pass cookies. A BrowserCheck pass only proves a
+// solved proof of work, so it must never verify as a clearance.
+const (
+	purposeClearance    = "clearance"
+	purposeBrowserCheck = "browsercheck"
+)
+
+// clearanceMAC signs a pass cookie body for purpose with Config.ClearanceSecret
+// (or Secret), so rotating ClearanceSecret revokes all clearances without
+// touching nonces and tokens.
+func (c *Captcha) clearanceMAC(purpose, body string, r *http.Request) string {
+	key := c.cfg.ClearanceSecret
+	if len(key) == 0 {
+		key = c.secret
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(purpose + "|" + body + "|" + c.jsCookieBinding(r)))
+	return b64(m.Sum(nil))
+}
+
+// checkClearance returns the clearance bonus for a valid gc_clearance cookie,
+// a penalty for a forged one, and nothing for a missing or expired one.
+func (c *Captcha) checkClearance(r *http.Request) (int, string) {
+	if !c.cfg.Clearance {
+		return 0, ""
+	}
+	ck, err := r.Cookie(ClearanceCookie)
+	if err != nil || ck.Value == "" {
+		return 0, ""
+	}
+	switch err := c.verifyClearance(r, purposeClearance, ck.Value); {
+	case errors.Is(err, errExpired):
+		return 0, ""
+	case err != nil:
+		return -2, "bad_clearance"
+	}
+	return c.cfg.ClearanceBonus, "clearance"
+}
+
+// verifyClearance checks a pass cookie value signed for purpose.
+func (c *Captcha) verifyClearance(r *http.Request, purpose, v string) error {
+	i := strings.LastIndexByte(v, '.')
+	if i <= 0 {
+		return errBadSignature
+	}
+	body, mac := v[:i], v[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(c.clearanceMAC(purpose, body, r))) {
+		return errBadSignature
+	}
+	exp, err := strconv.ParseInt(strings.SplitN(body, ".", 2)[0], 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	if time.Now().Unix() > exp {
+		return errExpired
+	}
+	return nil
+}
+
+// CheckRequestWithClearance is CheckRequest for handlers that can set cookies.
+// With Config.Clearance, a confident pass (score >= ClearanceMinScore) gets a
+// gc_clearance cookie bound to the client's IP range and User-Agent. While it
+// is valid, later submissions get ClearanceBonus and skip the client-side
+// signals (timestamp, JS token, behavior, model); honeypot, rate limit and
+// content checks still apply. An existing clearance is not extended.
+func (c *Captcha) CheckRequestWithClearance(w http.ResponseWriter, r *http.Request) bool {
+	blocked, score := c.evaluate(r)
+	if blocked || !c.cfg.Clearance || score < c.cfg.ClearanceMinScore {
+		return blocked
+	}
+	if ck, err := r.Cookie(ClearanceCookie); err == nil && c.verifyClearance(r, purposeClearance, ck.Value) == nil {
+		return blocked
+	}
+	c.setClearance(w, r, ClearanceCookie, purposeClearance, c.cfg.ClearanceTTL)
+	return blocked
+}
+
+// setClearance sets a fresh pass cookie (gc_clearance or gc_browser) for the
+// request's client.
+func (c *Captcha) setClearance(w http.ResponseWriter, r *http.Request, name, purpose string, ttl time.Duration) {
+	body := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10) + "." + b64(randomBytes(12))
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    body + "." + c.clearanceMAC(purpose, body, r),
+		Path:     "/",
+		MaxAge:   int(ttl / time.Second),
+		HttpOnly: true,
+		Secure:   c.forwardedHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,100 @@
+package gocaptcha
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// scriptIntegrity is the SRI hash of the embedded gocaptcha.js.
+var scriptIntegrity = func() string {
+	if embeddedScript.data == nil {
+		return ""
+	}
+	return sri(embeddedScript.data)
+}()
+
+// sri returns the Subresource Integrity value ("sha384-...") of data.
+func sri(data []byte) string {
+	sum := sha512.Sum384(data)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ScriptIntegrity returns the SRI hash of the embedded gocaptcha.js, for
+// <script integrity="..."> when you write the tag yourself. It only matches
+// the file served by JSHandler or Handler (or an unmodified copy).
+func ScriptIntegrity() string {
+	return scriptIntegrity
+}
+
+// nonceAttr returns ` nonce="..."` or "".
+func nonceAttr(nonce string) string {
+	if nonce == "" {
+		return ""
+	}
+	return ` nonce="` + html.EscapeString(nonce) + `"`
+}
+
+// integrityAttr returns the integrity and crossorigin attributes for data
+// when Config.SRI is set.
+func (c *Captcha) integrityAttr(data []byte) string {
+	if !c.cfg.SRI {
+		return ""
+	}
+	return ` integrity="` + sri(data) + `" crossorigin="anonymous"`
+}
+
+// cspNonce returns the CSP nonce for r from Config.CSPNonce, if any.
+func (c *Captcha) cspNonce(r *http.Request) string {
+	if r == nil || c.cfg.CSPNonce == nil {
+		return ""
+	}
+	return c.cfg.CSPNonce(r)
+}
+
+// nonceFromCSP extracts the first 'nonce-...' source from a
+// Content-Security-Policy header value.
+func nonceFromCSP(policy string) string {
+	for _, f := range strings.Fields(policy) {
+		f = strings.TrimSuffix(f, ";")
+		if strings.HasPrefix(f, "'nonce-") && strings.HasSuffix(f, "'") && len(f) > len("'nonce-'") {
+			return f[len("'nonce-") : len(f)-1]
+		}
+	}
+	return ""
+}
+
+// honeypotClass is the CSS class hiding the honeypot. It is derived from the
+// field name, so it differs between deployments but is stable for the
+// stylesheet served by Handler.
+func (c *Captcha) honeypotClass() string {
+	sum := sha256.Sum256([]byte(c.sign("css", c.fieldName)))
+	return "gc" + hex.EncodeToString(sum[:5])
+}
+
+func (c *Captcha) honeypotCSS() string {
+	sel := "." + c.honeypotClass()
+	for _, d := range c.decoys {
+		sel += ",." + c.decoyClass(d.Name)
+	}
+	return sel + "{position:absolute!important;left:-10000px!important;top:auto;width:1px;height:1px;overflow:hidden}\n"
+}
+
+// stylesheet is the CSS served as gocaptcha.css: badge and honeypot rules.
+func (c *Captcha) stylesheet() string {
+	return BadgeCSS() + c.honeypotCSS()
+}
+
+// stylesheetHTML returns the <link> for gocaptcha.css when
+// Config.ExternalStyles is set.
+func (c *Captcha) stylesheetHTML(nonce string) string {
+	if !c.cfg.ExternalStyles {
+		return ""
+	}
+	return `<link rel="stylesheet" href="` + html.EscapeString(c.endpoint("gocaptcha.css")) + `"` +
+		nonceAttr(nonce) + c.integrityAttr([]byte(c.stylesheet())) + `>`
+}
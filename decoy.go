@@ -0,0 +1,210 @@
+package gocaptcha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DecoyField is one decoy honeypot rendered into forms (see Config.Decoys).
+type DecoyField struct {
+	Name string
+	Type string // "email", "url", "checkbox", "textarea" or "text"
+}
+
+// decoyDictionary holds plausible names per decoy type. They avoid the
+// tokens browsers and password managers key autofill on (name, email, phone,
+// fax, address, company, title, notes), so a hidden decoy stays empty for
+// people, and are unlikely to clash with real fields; Config.DecoyNames
+// replaces them per type.
+var decoyDictionary = map[string][]string{
+	"email":    {"relay_to", "cc_to", "forward_to", "echo_to", "notify_to"},
+	"url":      {"ref_link", "source_link", "origin_link", "link_ref", "site_ref"},
+	"checkbox": {"newsletter_optin", "accept_marketing", "partner_offers", "remember_device", "sms_updates"},
+	"textarea": {"memo_extra", "ticket_memo", "draft_text", "aux_body", "reply_draft"},
+	"text":     {"ref_code", "batch_code", "routing_tag", "ticket_ref", "handle_alt"},
+}
+
+// decoySkipField carries the decoys left out of a form because it has real
+// fields with their names. It is signed together with the form's
+// gc_form_token, so it expires and is spent with that token and cannot
+// switch decoys off on other forms.
+const decoySkipField = "gc_decoy_skip"
+
+// decoyNames returns the name dictionary of a decoy type: Config.DecoyNames
+// or the built-in one.
+func (c *Captcha) decoyNames(typ string) []string {
+	if names := c.cfg.DecoyNames[typ]; len(names) > 0 {
+		return names
+	}
+	return decoyDictionary[typ]
+}
+
+// pickName picks an unused name from names, starting at a position derived
+// from the secret and salt, and marks it used. It returns "" when all are
+// taken.
+func (c *Captcha) pickName(names []string, salt string, used map[string]bool) string {
+	if len(names) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(c.sign("decoy", salt)))
+	start := int(binary.BigEndian.Uint32(sum[:4]) % uint32(len(names)))
+	for j := 0; j < len(names); j++ {
+		if name := names[(start+j)%len(names)]; !used[name] {
+			used[name] = true
+			return name
+		}
+	}
+	return ""
+}
+
+// honeypotName picks the name of the main honeypot from the text decoy
+// dictionary, so it looks like any other optional field.
+func (c *Captcha) honeypotName() string {
+	return c.pickName(c.decoyNames("text"), "honeypot", map[string]bool{})
+}
+
+// buildDecoys picks the decoy names for Config.Decoys. Picks are derived
+// from the secret, so instances sharing Secret render the same names.
+func (c *Captcha) buildDecoys() []DecoyField {
+	var out []DecoyField
+	used := map[string]bool{c.fieldName: true}
+	for i, typ := range c.cfg.Decoys {
+		typ = strings.ToLower(strings.TrimSpace(typ))
+		if name := c.pickName(c.decoyNames(typ), typ+"|"+strconv.Itoa(i), used); name != "" {
+			out = append(out, DecoyField{Name: name, Type: typ})
+		}
+	}
+	return out
+}
+
+// DecoyFields returns the decoy honeypots, for forms written by hand. Hide
+// each one (e.g. with a wrapper class positioned off-screen) and leave it
+// empty; captchaFields and InjectHTML render them for you.
+func (c *Captcha) DecoyFields() []DecoyField {
+	return append([]DecoyField(nil), c.decoys...)
+}
+
+// isHoneypot reports whether name is the honeypot or one of the decoys
+// rendered into the submitted form. Their values are not content and are
+// skipped by the content checks.
+func (c *Captcha) isHoneypot(r *http.Request, name string) bool {
+	if name == c.fieldName {
+		return true
+	}
+	for _, d := range c.formDecoys(r) {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// formDecoys returns the decoys rendered into the submitted form: all of
+// them, minus those listed in a decoySkipField signed with the form's
+// valid, unexpired form token.
+func (c *Captcha) formDecoys(r *http.Request) []DecoyField {
+	v := r.FormValue(decoySkipField)
+	tok := strings.TrimSpace(r.PostFormValue(FormTokenField))
+	i := strings.LastIndexByte(v, '.')
+	if i <= 0 || c.verify("form", tok) != nil ||
+		!hmac.Equal([]byte(v[i+1:]), []byte(c.decoySkipMAC(tok, v[:i]))) {
+		return c.decoys
+	}
+	skip := map[string]bool{}
+	for _, name := range strings.Split(v[:i], ",") {
+		skip[name] = true
+	}
+	var out []DecoyField
+	for _, d := range c.decoys {
+		if !skip[d.Name] {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// decoyClass is the CSS class hiding a decoy, derived like honeypotClass.
+func (c *Captcha) decoyClass(name string) string {
+	sum := sha256.Sum256([]byte(c.sign("css", name)))
+	return "gc" + hex.EncodeToString(sum[:5])
+}
+
+// decoySkipMAC signs a decoySkipField list for the form token formToken.
+func (c *Captcha) decoySkipMAC(formToken, list string) string {
+	return c.sign("decoyskip", formToken+"|"+list)
+}
+
+// decoysHTML renders the decoys, each in its own hidden wrapper with a label.
+// Decoys named like a field in used (the form's own fields) are left out, so
+// the form keeps its field, and listed in a decoySkipField bound to
+// formToken, the form's gc_form_token.
+func (c *Captcha) decoysHTML(used map[string]bool, formToken string) string {
+	var b strings.Builder
+	var skipped []string
+	for _, d := range c.decoys {
+		if used[d.Name] {
+			skipped = append(skipped, d.Name)
+			continue
+		}
+		name := html.EscapeString(d.Name)
+		label := strings.ReplaceAll(strings.TrimRight(d.Name, "0123456789"), "_", " ")
+		if label != "" {
+			label = strings.ToUpper(label[:1]) + label[1:]
+		}
+		b.WriteString(`<div class="` + c.decoyClass(d.Name) + `" aria-hidden="true"><label>` + html.EscapeString(label) + ` `)
+		switch d.Type {
+		case "textarea":
+			b.WriteString(`<textarea name="` + name + `" tabindex="-1" autocomplete="off"></textarea>`)
+		case "checkbox":
+			b.WriteString(`<input type="checkbox" name="` + name + `" value="1" tabindex="-1">`)
+		default:
+			b.WriteString(`<input type="` + d.Type + `" name="` + name + `" value="" tabindex="-1" autocomplete="off">`)
+		}
+		b.WriteString(`</label></div>`)
+	}
+	if len(skipped) > 0 {
+		list := strings.Join(skipped, ",")
+		b.WriteString(`<input type="hidden" name="` + decoySkipField + `" value="` + html.EscapeString(list+"."+c.decoySkipMAC(formToken, list)) + `">`)
+	}
+	return b.String()
+}
+
+// checkDecoys inspects the decoys of the submitted form. Any value blocks
+// (decoy_filled:<type>), with two exceptions that browsers produce despite
+// autocomplete=off: a value repeating another field, and an email address
+// in an email decoy. Those only cost 2 points (decoy_autofill).
+func (c *Captcha) checkDecoys(r *http.Request) (penalty int, reason string, block bool) {
+	for _, d := range c.formDecoys(r) {
+		val := strings.TrimSpace(r.FormValue(d.Name))
+		if val == "" {
+			continue
+		}
+		autofill := d.Type == "email" && emailRe.MatchString(val) || d.Type != "checkbox" && c.repeatsField(r, val)
+		if !autofill {
+			return 0, "decoy_filled:" + d.Type, true
+		}
+		penalty, reason = -2, "decoy_autofill"
+	}
+	return penalty, reason, false
+}
+
+// repeatsField reports whether val is also the value of a real (non-honeypot) field.
+func (c *Captcha) repeatsField(r *http.Request, val string) bool {
+	for k, vals := range r.Form {
+		if c.isHoneypot(r, k) {
+			continue
+		}
+		for _, v := range vals {
+			if strings.EqualFold(strings.TrimSpace(v), val) {
+				return true
+			}
+		}
+	}
+	return false
+}
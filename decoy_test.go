@@ -0,0 +1,124 @@
+package gocaptcha
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDecoysSkipFormFields(t *testing.T) {
+	c := New(Config{Decoys: []string{"url", "textarea"}, DecoyNames: map[string][]string{
+		"url":      {"homepage"},
+		"textarea": {"memo_extra"},
+	}})
+	page := `<html><body><form method="post"><input name="homepage"><textarea name="message"></textarea></form></body></html>`
+	h := c.InjectHTML(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, page)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	out := rec.Body.String()
+	if n := strings.Count(out, `name="homepage"`); n != 1 {
+		t.Fatalf("homepage rendered %d times, want 1 (the form's own field):\n%s", n, out)
+	}
+	if !strings.Contains(out, `name="memo_extra"`) {
+		t.Fatalf("memo_extra decoy missing:\n%s", out)
+	}
+	value := func(name string) string {
+		m := regexp.MustCompile(`name="` + name + `" value="([^"]+)"`).FindStringSubmatch(out)
+		if m == nil {
+			t.Fatalf("no %s input:\n%s", name, out)
+		}
+		return m[1]
+	}
+	skip, tok := value(decoySkipField), value(FormTokenField)
+
+	submit := func(form url.Values) *http.Request {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// The real homepage field is neither a decoy nor skipped by the content checks
+	r := submit(url.Values{"homepage": {"https://example.org"}, decoySkipField: {skip}, FormTokenField: {tok}})
+	if _, why, block := c.checkDecoys(r); block || why != "" {
+		t.Errorf("checkDecoys with skip = %q, %v, want no finding", why, block)
+	}
+	if c.isHoneypot(r, "homepage") {
+		t.Error("isHoneypot(homepage) with a valid skip list")
+	}
+
+	// The skip list only works with the form token it was issued for
+	for name, form := range map[string]url.Values{
+		"forged":        {decoySkipField: {"homepage.forged"}, FormTokenField: {tok}},
+		"no form token": {decoySkipField: {skip}},
+		"other form":    {decoySkipField: {skip}, FormTokenField: {c.FormToken()}},
+	} {
+		form.Set("homepage", "https://example.org")
+		if _, why, block := c.checkDecoys(submit(form)); !block {
+			t.Errorf("%s: checkDecoys = %q, not blocked", name, why)
+		}
+	}
+}
+
+func TestCheckDecoysValues(t *testing.T) {
+	c := New(Config{Decoys: []string{"email", "text", "textarea", "checkbox"}, DecoyNames: map[string][]string{
+		"email":    {"relay_to"},
+		"text":     {"ref_code", "batch_code"},
+		"textarea": {"memo_extra"},
+		"checkbox": {"partner_offers"},
+	}})
+	text := c.DecoyFields()[1].Name
+	tests := []struct {
+		name  string
+		form  url.Values
+		pen   int
+		block bool
+	}{
+		{"empty", url.Values{text: {""}}, 0, false},
+		{"autofilled email", url.Values{"relay_to": {"jane@example.org"}}, -2, false},
+		{"repeated value", url.Values{text: {"Jane Doe"}, "name": {"Jane Doe"}}, -2, false},
+		{"repeated multi-line value", url.Values{"memo_extra": {"a\nb"}, "message": {"a\nb"}}, -2, false},
+		{"junk in email decoy", url.Values{"relay_to": {"test"}}, 0, true},
+		{"short junk", url.Values{text: {"test"}}, 0, true},
+		{"name nobody typed", url.Values{text: {"John Smith"}}, 0, true},
+		{"link", url.Values{text: {"https://spam.example"}}, 0, true},
+		{"spam text", url.Values{"memo_extra": {"Buy now\nCheap pills"}}, 0, true},
+		{"checked checkbox", url.Values{"partner_offers": {"1"}}, 0, true},
+		{"checkbox repeating a field", url.Values{"partner_offers": {"1"}, "qty": {"1"}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			pen, why, block := c.checkDecoys(r)
+			if pen != tt.pen || block != tt.block {
+				t.Errorf("checkDecoys() = %d, %q, %v, want %d, %v", pen, why, block, tt.pen, tt.block)
+			}
+		})
+	}
+}
+
+func TestHoneypotNameFromDictionary(t *testing.T) {
+	c := New(Config{Secret: []byte("s"), Decoys: []string{"text"}})
+	if strings.HasPrefix(c.HoneypotField(), "extra_") {
+		t.Errorf("honeypot %q uses the extra_ prefix", c.HoneypotField())
+	}
+	if d := c.DecoyFields(); len(d) != 1 || d[0].Name == c.HoneypotField() {
+		t.Errorf("text decoy %v clashes with honeypot %q", d, c.HoneypotField())
+	}
+	if again := New(Config{Secret: []byte("s")}); again.HoneypotField() != c.HoneypotField() {
+		t.Error("honeypot name differs between instances sharing Secret")
+	}
+}
@@ -0,0 +1,155 @@
+package gocaptcha
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Content roles understood by the form content checks.
+const (
+	RoleName    = "name"
+	RoleEmail   = "email"
+	RoleURL     = "url"
+	RoleMessage = "message"
+	RolePhone   = "phone"
+	RoleSubject = "subject"
+)
+
+// FieldRole maps form field names to a content role (RoleName, RoleEmail,
+// RoleURL, RoleMessage, RolePhone, RoleSubject).
+//
+// Pattern is compared case-insensitively: first as an exact name, then as a
+// glob in path.Match syntax ("*email*", "contact.*"). Note that '[' starts a
+// character class in globs, so "contact[name]" only matches that exact name;
+// use "contact\[*\]" or a regular expression for bracketed names. With Regex
+// set, Pattern is a regular expression matched against the whole name.
+type FieldRole struct {
+	Pattern string
+	Regex   bool
+	Role    string
+}
+
+// defaultFieldRoles are the field names recognized without configuration.
+var defaultFieldRoles = []FieldRole{
+	{Pattern: "name", Role: RoleName}, {Pattern: "full_name", Role: RoleName},
+	{Pattern: "fullname", Role: RoleName}, {Pattern: "username", Role: RoleName},
+	{Pattern: "email", Role: RoleEmail},
+	{Pattern: "website", Role: RoleURL}, {Pattern: "url", Role: RoleURL}, {Pattern: "site", Role: RoleURL},
+	{Pattern: "message", Role: RoleMessage}, {Pattern: "msg", Role: RoleMessage}, {Pattern: "comment", Role: RoleMessage},
+	{Pattern: "content", Role: RoleMessage}, {Pattern: "bio", Role: RoleMessage}, {Pattern: "body", Role: RoleMessage},
+	{Pattern: "phone", Role: RolePhone}, {Pattern: "tel", Role: RolePhone}, {Pattern: "telephone", Role: RolePhone},
+	{Pattern: "subject", Role: RoleSubject}, {Pattern: "title", Role: RoleSubject},
+}
+
+type compiledRole struct {
+	pattern string
+	re      *regexp.Regexp
+	role    string
+}
+
+// compileFieldRoles lowercases globs and compiles regular expressions.
+// Invalid patterns are skipped.
+func compileFieldRoles(roles []FieldRole) []compiledRole {
+	out := make([]compiledRole, 0, len(roles))
+	for _, fr := range roles {
+		role := strings.ToLower(strings.TrimSpace(fr.Role))
+		if role == "website" {
+			role = RoleURL
+		}
+		if fr.Pattern == "" || role == "" {
+			continue
+		}
+		cr := compiledRole{pattern: strings.ToLower(fr.Pattern), role: role}
+		if fr.Regex {
+			re, err := regexp.Compile(`(?i)^(?:` + fr.Pattern + `)$`)
+			if err != nil {
+				continue
+			}
+			cr.re = re
+		} else if _, err := path.Match(cr.pattern, ""); err != nil {
+			continue
+		}
+		out = append(out, cr)
+	}
+	return out
+}
+
+func matchRole(roles []compiledRole, name string) string {
+	lower := strings.ToLower(name)
+	for _, cr := range roles {
+		if cr.re != nil {
+			if cr.re.MatchString(name) {
+				return cr.role
+			}
+			continue
+		}
+		if cr.pattern == lower {
+			return cr.role
+		}
+		if ok, _ := path.Match(cr.pattern, lower); ok {
+			return cr.role
+		}
+	}
+	return ""
+}
+
+// fieldRole returns the content role of the form field name for r: route
+// mappings first, then Config.FieldRoles, then the built-in names.
+func (c *Captcha) fieldRole(r *http.Request, name string) string {
+	if rt := c.route(r); rt != nil {
+		if role := matchRole(c.routeRoles[rt.PathPrefix], name); role != "" {
+			return role
+		}
+	}
+	return matchRole(c.roles, name)
+}
+
+// formFields groups the submitted fields into content roles (see FieldRole).
+// Multiple fields with the same role are joined with newlines. Values are
+// passed through NormalizeText.
+func (c *Captcha) formFields(r *http.Request) map[string]string {
+	fields := map[string]string{}
+	for k, vals := range r.Form {
+		if c.isHoneypot(r, k) {
+			continue
+		}
+		role := c.fieldRole(r, k)
+		if role == "" {
+			continue
+		}
+		for _, v := range vals {
+			v = NormalizeText(v)
+			if strings.TrimSpace(v) == "" {
+				continue
+			}
+			if fields[role] != "" {
+				fields[role] += "\n"
+			}
+			fields[role] += v
+		}
+	}
+	return fields
+}
+
+var phoneRe = regexp.MustCompile(`^[0-9+()./\s-]+(?:\s*(?:x|ext\.?)\s*[0-9]{1,6})?$`)
+
+// validPhone reports whether s looks like a phone number: 7 to 15 digits made
+// up only of digits, spaces and + ( ) . / - separators, with an optional extension.
+func validPhone(s string) bool {
+	if !phoneRe.MatchString(strings.ToLower(s)) {
+		return false
+	}
+	main := strings.ToLower(s)
+	if i := strings.Index(main, "x"); i >= 0 {
+		main = main[:i]
+	}
+	digits := 0
+	for _, r := range main {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= 7 && digits <= 15
+}
@@ -3,3 +3,5 @@ module github.com/dragstor/gocaptcha
 go 1.20
 
 require github.com/mattn/go-sqlite3 v1.14.32
+
+require golang.org/x/text v0.14.0
@@ -9,9 +9,10 @@ import (
 	"encoding/json"
 	"errors"
 	"math"
-	"math/rand"
 	"net"
 	"net/http"
+	"net/netip"
+	"path"
 	"regexp"
 	"sort"
 	"strconv"
@@ -27,32 +28,182 @@ import (
 type Config struct {
 	ShowBadge      bool
 	BadgeMessage   string
+	Badge          BadgeOptions // position, theme, size, privacy link, translations
 	RateLimitTTL   time.Duration
 	RateLimitMax   int
 	EnableStorage  bool
 	DBPath         string
 	BlockThreshold int // Decision threshold (score <= BlockThreshold => block). If 0, defaults to -5 for backward compatibility.
 
+	// IPv4Prefix and IPv6Prefix are the network sizes the rate limiter, the reputation
+	// store, TopPrefixes and the cookie bindings (JS, clearance, BrowserCheck) count
+	// clients by, since one host often controls a whole IPv6 /64. Default to 32
+	// (single address) and 64.
+	IPv4Prefix int
+	IPv6Prefix int
+
 	// When true, attempts to determine the real client IP from proxy headers
 	// (Forwarded, X-Forwarded-For, X-Real-IP). Only enable this if your app is
 	// behind a trusted reverse proxy that sets these headers correctly.
 	TrustProxyHeaders bool
 
+	// TrustedProxies lists the networks of your proxies (see CloudflareProxies,
+	// LoopbackProxies, PrivateProxies). When set, proxy headers are read only from these
+	// peers and ForwardedHeader is walked from the right, skipping trusted hops, so clients
+	// cannot spoof their address. It replaces TrustProxyHeaders.
+	TrustedProxies []netip.Prefix
+	// ForwardedHeader is the one header your proxy writes: "X-Forwarded-For" (default),
+	// "Forwarded" or "X-Real-IP". Other headers are ignored with TrustedProxies.
+	ForwardedHeader string
+
 	// Optional bypass controls to exclude certain requests (e.g., OAuth callbacks) from checks.
 	SkipPaths []string                   // Any request whose URL.Path has one of these prefixes will bypass checks.
 	SkipIf    func(r *http.Request) bool // If provided and returns true, the request bypasses checks.
+
+	// Request bodies (url-encoded, multipart or JSON) are read up to MaxBodyBytes and restored
+	// for the downstream handler. Larger bodies are blocked (unless bypassed). Defaults to 1 MiB.
+	MaxBodyBytes int64
+	// Name of an optional JSON sub-object holding the captcha fields ({"gocaptcha": {"ts": ...}}).
+	// Defaults to "gocaptcha".
+	JSONCaptchaKey string
+
+	// Secret signs nonces and tokens. If empty, a random secret is generated at startup, so
+	// tokens do not survive restarts and are not shared between instances.
+	Secret []byte
+	// TokenTTL is how long a nonce (and the X-GoCaptcha-Token built from it) stays valid. Defaults to 30 minutes.
+	TokenTTL time.Duration
+
+	// EndpointPath is where Handler is mounted (config.js, nonce, ...). Defaults to "/gocaptcha/".
+	EndpointPath string
+	// ManualRender stops the script from instrumenting every form; only forms with a
+	// data-gocaptcha attribute or passed to GoCaptcha.render() are handled.
+	ManualRender bool
+	// ScriptPath is the URL of gocaptcha.js used by InjectHTML. Defaults to "/static/js/gocaptcha.js".
+	ScriptPath string
+
+	// CSPNonce returns the Content-Security-Policy nonce for a request; it is added to the
+	// <script>/<style> elements written by InjectHTML, BadgeHTMLFor and the template helpers.
+	CSPNonce func(r *http.Request) string
+	// ExternalStyles renders no <style> elements: badge and honeypot styles come from the
+	// gocaptcha.css endpoint of Handler (linked by InjectHTML and {{captchaStyles}}).
+	ExternalStyles bool
+	// SRI adds integrity attributes to the script and stylesheet tags. Only enable it when
+	// ScriptPath serves the embedded gocaptcha.js (JSHandler, Handler or an unmodified copy).
+	SRI bool
+	// VersionedScript makes the generated script tags use ScriptURL() (gocaptcha.<hash>.js,
+	// cached for a year). ScriptPath must be served by JSHandler or Handler.
+	VersionedScript bool
+
+	// RotateFields serves a per-epoch build of the script (through Handler) with derived
+	// hidden-field names, cookie name and js_token routine; CheckRequest accepts only the
+	// current and previous epoch's names; the static X-GoCaptcha-TS/-JS-Token/-Behavior
+	// headers are ignored. Mount Handler and let it serve the script.
+	RotateFields bool
+	// RotationEpoch is how often the names rotate. Defaults to 24 hours.
+	RotationEpoch time.Duration
+
+	// SignedJSCookie replaces js_captcha=enabled with an HttpOnly cookie set by Handler's
+	// cookie endpoint: an HMAC over expiry, nonce, client IP prefix and User-Agent hash.
+	SignedJSCookie bool
+	// JSCookieTTL is the lifetime of the signed JS cookie. Defaults to 24 hours.
+	JSCookieTTL time.Duration
+
+	// Clearance makes CheckRequestWithClearance issue a gc_clearance cookie on a confident pass.
+	Clearance bool
+	// ClearanceTTL is the clearance lifetime. Defaults to 30 minutes.
+	ClearanceTTL time.Duration
+	// ClearanceMinScore is the lowest final score that earns a clearance. Defaults to 0 (no penalties).
+	ClearanceMinScore int
+	// ClearanceBonus is added to the score of cleared requests. Defaults to 3.
+	ClearanceBonus int
+	// ClearanceSecret signs clearances (defaults to Secret). Change it to revoke all of them.
+	ClearanceSecret []byte
+
+	// ChallengeDifficulty is the number of leading zero bits BrowserCheck's proof of work
+	// must find (about 2^n SHA-256 hashes in the browser). Defaults to 16.
+	ChallengeDifficulty int
+	// BrowserCheckTTL is the lifetime of the gc_browser cookie a solved challenge earns. Defaults to 30 minutes.
+	BrowserCheckTTL time.Duration
+	// GoodBots are crawlers BrowserCheck lets through after reverse and forward DNS
+	// verification. Nil means DefaultGoodBots; an empty slice exempts none.
+	GoodBots []GoodBot
+
+	// Decoys adds decoy honeypots of these types ("email", "url", "checkbox", "textarea",
+	// "text") to rendered forms, with realistic names from a built-in dictionary. Any
+	// decoy submitted with a value blocks, except autofill-like repeats of another field.
+	Decoys []string
+	// DecoyNames replaces the built-in name dictionary per type.
+	DecoyNames map[string][]string
+
+	// Traps adds a hidden trap link to pages (InjectHTML, captchaTrap) and serves it from
+	// Handler; clients that follow it are flagged in the reputation store.
+	Traps bool
+	// TrapPath overrides the trap link URL (default: a name under EndpointPath derived from Secret).
+	TrapPath string
+	// ReputationTTL is how long a flagged network stays flagged. Defaults to 24 hours.
+	ReputationTTL time.Duration
+	// ReputationPenalty is subtracted from the score of flagged clients. Defaults to 4.
+	ReputationPenalty int
+
+	// Optional trained behavior model (see LoadModel for the JSON format). The file is
+	// reloaded automatically when it changes on disk.
+	ModelPath   string
+	ModelWeight int // Max points the model adds or subtracts (p=1 => -ModelWeight, p=0 => +ModelWeight). Defaults to 4.
+
+	// Naive Bayes content classifier (trained via Train). Its spam probability for the
+	// message is a penalty of BayesWeight (p >= 0.9) or half of it (p >= 0.75).
+	BayesWeight  int // Defaults to 3. Set to a negative value to disable the signal.
+	BayesMinDocs int // Minimum trained examples per class before the signal is used. Defaults to 10.
+
+	KeywordPenaltyCap int // Max total penalty from weighted spam keyword hits. Defaults to 6.
+
+	// Allowed writing systems for form fields. When nil, the latin_only database flag
+	// (if set) applies a Latin-only hard block as before.
+	Scripts *ScriptPolicy
+
+	// Expected languages of the message field (embedded n-gram detector). Nil disables detection.
+	Languages *LanguagePolicy
+
+	// Maps custom form field names (globs or regexes) to content roles, checked before the
+	// built-in names (name, email, website, message, ...).
+	FieldRoles []FieldRole
+
+	// Per-path overrides (longest PathPrefix wins).
+	Routes []Route
 }
 
 type Captcha struct {
 	cfg       Config
 	fieldName string
+	decoys    []DecoyField // Config.Decoys with their chosen names
 	db        *sql.DB
+	secret    []byte
+	nonces    nonceCache
 
 	rateMu  sync.Mutex
-	rateMap map[string][]time.Time // IP -> request timestamps
+	rateMap map[string][]time.Time // IP network (see ipNetwork) -> request timestamps
+
+	roles      []compiledRole            // Config.FieldRoles + defaults
+	routeRoles map[string][]compiledRole // Route.PathPrefix -> Route.FieldRoles
+
+	model   modelScorer
+	bayes   *bayesClassifier
+	kwCache keywordCache
+
+	kwMemMu      sync.RWMutex
+	kwMem        []Keyword // keywords when storage is disabled
+	kwMemVersion int
+
+	rotated  rotatedScripts // per-epoch script build (RotateFields)
+	goodBots goodBotCache   // DNS-verified crawler IPs (BrowserCheck)
+
+	reputation reputationStore // flagged networks (Flag, trap link)
 }
 
+// New returns a Captcha for cfg, filling in defaults for zero fields. It
+// panics on an unknown script name in a ScriptPolicy.
 func New(cfg Config) *Captcha {
+	checkScriptNames(cfg)
 	// Backward-compatible defaults
 	if cfg.RateLimitTTL == 0 {
 		cfg.RateLimitTTL = 1 * time.Minute
@@ -60,15 +211,89 @@ func New(cfg Config) *Captcha {
 	if cfg.RateLimitMax == 0 {
 		cfg.RateLimitMax = 5
 	}
+	if cfg.IPv4Prefix <= 0 || cfg.IPv4Prefix > 32 {
+		cfg.IPv4Prefix = 32
+	}
+	if cfg.IPv6Prefix <= 0 || cfg.IPv6Prefix > 128 {
+		cfg.IPv6Prefix = 64
+	}
 	if cfg.BlockThreshold == 0 {
 		cfg.BlockThreshold = -5
 	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.JSONCaptchaKey == "" {
+		cfg.JSONCaptchaKey = "gocaptcha"
+	}
+	if cfg.ScriptPath == "" {
+		cfg.ScriptPath = "/static/js/gocaptcha.js"
+	}
+	if cfg.ClearanceTTL == 0 {
+		cfg.ClearanceTTL = 30 * time.Minute
+	}
+	if cfg.ClearanceBonus == 0 {
+		cfg.ClearanceBonus = 3
+	}
+	if cfg.BrowserCheckTTL == 0 {
+		cfg.BrowserCheckTTL = 30 * time.Minute
+	}
+	if cfg.ChallengeDifficulty <= 0 {
+		cfg.ChallengeDifficulty = 16
+	}
+	if cfg.ReputationTTL == 0 {
+		cfg.ReputationTTL = 24 * time.Hour
+	}
+	if cfg.ReputationPenalty == 0 {
+		cfg.ReputationPenalty = 4
+	}
+	if cfg.JSCookieTTL == 0 {
+		cfg.JSCookieTTL = 24 * time.Hour
+	}
+	if cfg.RotationEpoch < time.Minute {
+		cfg.RotationEpoch = 24 * time.Hour
+	}
+	if cfg.EndpointPath == "" {
+		cfg.EndpointPath = "/gocaptcha/"
+	}
+	if cfg.TokenTTL == 0 {
+		cfg.TokenTTL = 30 * time.Minute
+	}
+	if cfg.ModelWeight == 0 {
+		cfg.ModelWeight = 4
+	}
+	if cfg.BayesWeight == 0 {
+		cfg.BayesWeight = 3
+	}
+	if cfg.BayesMinDocs == 0 {
+		cfg.BayesMinDocs = 10
+	}
+	if cfg.KeywordPenaltyCap == 0 {
+		cfg.KeywordPenaltyCap = 6
+	}
 
 	c := &Captcha{
-		cfg:       cfg,
-		fieldName: "extra_" + randSeq(6),
-		rateMap:   make(map[string][]time.Time),
+		cfg:     cfg,
+		rateMap: make(map[string][]time.Time),
+		secret:  cfg.Secret,
+		model:   modelScorer{path: cfg.ModelPath},
+		bayes:   newBayesClassifier(),
+	}
+	c.reputation.m = make(map[string]reputationEntry)
+	if len(c.secret) == 0 {
+		c.secret = randomBytes(32)
+	}
+	c.fieldName = c.honeypotName()
+	c.decoys = c.buildDecoys()
+	c.model.current() // initial load
+	c.roles = compileFieldRoles(append(append([]FieldRole{}, cfg.FieldRoles...), defaultFieldRoles...))
+	c.routeRoles = make(map[string][]compiledRole)
+	for _, rt := range cfg.Routes {
+		if len(rt.FieldRoles) > 0 {
+			c.routeRoles[rt.PathPrefix] = compileFieldRoles(rt.FieldRoles)
+		}
 	}
+	c.kwMem = defaultKeywords()
 	if cfg.EnableStorage {
 		if cfg.DBPath == "" {
 			cfg.DBPath = "captcha.db"
@@ -83,17 +308,45 @@ func New(cfg Config) *Captcha {
 				ua TEXT,
 				score INTEGER,
 				details TEXT,
-				timestamp TEXT DEFAULT CURRENT_TIMESTAMP
+				timestamp TEXT DEFAULT CURRENT_TIMESTAMP,
+				ip_prefix TEXT
 			)`)
+			// Older databases lack ip_prefix; fill it in for existing rows
+			if _, err := c.db.Exec(`ALTER TABLE captcha_logs ADD COLUMN ip_prefix TEXT`); err == nil {
+				c.backfillIPPrefixes()
+			}
 			// Keywords and configuration tables
-			c.db.Exec(`CREATE TABLE IF NOT EXISTS spam_keywords (id INTEGER PRIMARY KEY, keyword TEXT UNIQUE)`)
+			c.db.Exec(`CREATE TABLE IF NOT EXISTS spam_keywords (
+				id INTEGER PRIMARY KEY,
+				keyword TEXT UNIQUE,
+				weight INTEGER NOT NULL DEFAULT 3,
+				category TEXT NOT NULL DEFAULT '',
+				is_regex INTEGER NOT NULL DEFAULT 0
+			)`)
+			// Older databases lack the weight/category/regex columns (errors ignored when present)
+			c.db.Exec(`ALTER TABLE spam_keywords ADD COLUMN weight INTEGER NOT NULL DEFAULT 3`)
+			c.db.Exec(`ALTER TABLE spam_keywords ADD COLUMN category TEXT NOT NULL DEFAULT ''`)
+			c.db.Exec(`ALTER TABLE spam_keywords ADD COLUMN is_regex INTEGER NOT NULL DEFAULT 0`)
 			c.db.Exec(`CREATE TABLE IF NOT EXISTS captcha_config (key TEXT PRIMARY KEY, value TEXT)`)
 			// Default config: enforce Latin-only text
 			c.db.Exec(`INSERT OR IGNORE INTO captcha_config (key, value) VALUES ('latin_only','1')`)
-			// Seed default spam keywords (library users can add more later)
+			// Seed default spam keywords once (library users can add more later), so
+			// keywords removed with RemoveKeywords stay removed across restarts
+			seed := false
+			if res, err := c.db.Exec(`INSERT OR IGNORE INTO captcha_config (key, value) VALUES ('keywords_seeded','1')`); err == nil {
+				n, _ := res.RowsAffected()
+				seed = n == 1
+			}
 			for _, kw := range defaultKeywords() {
-				_, _ = c.db.Exec(`INSERT OR IGNORE INTO spam_keywords (keyword) VALUES (?)`, kw)
+				if seed {
+					_, _ = c.db.Exec(`INSERT OR IGNORE INTO spam_keywords (keyword, weight, category) VALUES (?, ?, ?)`, kw.Keyword, kw.Weight, kw.Category)
+				}
+				// Categorize seeds stored by older versions
+				_, _ = c.db.Exec(`UPDATE spam_keywords SET category = ? WHERE keyword = ? AND category = ''`, kw.Category, kw.Keyword)
 			}
+			c.initKeywordStorage()
+			c.initBayesStorage()
+			c.initReputationStorage()
 		}
 	}
 	return c
@@ -108,10 +361,18 @@ func (c *Captcha) Middleware() func(r *http.Request) bool {
 
 // CheckRequest analyzes the incoming request and returns true if it's likely a bot.
 func (c *Captcha) CheckRequest(r *http.Request) bool {
+	blocked, _ := c.evaluate(r)
+	return blocked
+}
+
+// evaluate runs all checks and returns the decision and the final score.
+func (c *Captcha) evaluate(r *http.Request) (bool, int) {
 	score := 0
 	reasons := []string{}
-	if err := r.ParseForm(); err != nil {
-		return true // suspicious if malformed form data
+	// Form, multipart or JSON body (restored afterwards for the downstream handler)
+	tooLarge, err := c.parseBody(r)
+	if err != nil {
+		return true, score // suspicious if malformed form data
 	}
 
 	ip := c.clientIP(r)
@@ -122,12 +383,20 @@ func (c *Captcha) CheckRequest(r *http.Request) bool {
 	// Early bypass (OAuth callbacks or configured skips)
 	if ok, why := c.shouldBypass(r); ok {
 		c.log(ip, ua, 0, []string{why})
-		return false
+		return false, score
+	}
+
+	// An unparsed body would hide the honeypot, decoys and content from every check below
+	if tooLarge != "" {
+		reasons = append(reasons, tooLarge)
+		c.log(ip, ua, score, reasons)
+		return true, score
 	}
 
-	// 1. Rate limiting
+	// 1. Rate limiting (per network, see Config.IPv6Prefix)
+	netKey := c.ipNetwork(ip)
 	c.rateMu.Lock()
-	hits := c.rateMap[ip]
+	hits := c.rateMap[netKey]
 	var recent []time.Time
 	for _, t := range hits {
 		if now.Sub(t) < c.cfg.RateLimitTTL {
@@ -135,53 +404,109 @@ func (c *Captcha) CheckRequest(r *http.Request) bool {
 		}
 	}
 	recent = append(recent, now)
-	c.rateMap[ip] = recent
+	c.rateMap[netKey] = recent
 	c.rateMu.Unlock()
 	if len(recent) > c.cfg.RateLimitMax {
 		score -= 3
 		reasons = append(reasons, "rate_limit_exceeded")
 	}
 
+	// 1b. Reputation (trap link hits and Flag calls)
+	if pen, why := c.checkReputation(r); pen != 0 {
+		score += pen
+		reasons = append(reasons, why)
+	}
+
 	// 2. Hidden extra field (honeypot)
 	if val := strings.TrimSpace(r.FormValue(c.fieldName)); val != "" {
 		reasons = append(reasons, "hidden_field_filled")
 		c.log(ip, ua, score, reasons)
-		return true
+		return true, score
 	}
-
-	// 2b. Latin-only enforcement (configurable)
-	if c.getConfigBool("latin_only", false) {
-		if !c.formIsLatinOnly(r) {
-			reasons = append(reasons, "non_latin_detected")
-			c.log(ip, ua, score, reasons)
-			return true
-		}
+	if pen, why, block := c.checkDecoys(r); block {
+		reasons = append(reasons, why)
+		c.log(ip, ua, score, reasons)
+		return true, score
+	} else if pen != 0 {
+		score += pen
+		reasons = append(reasons, why)
 	}
 
-	// 3. Timestamp (client JS writes current time in ms)
-	if tsStr := r.FormValue("ts"); tsStr != "" {
-		if ts, err := strconv.ParseInt(tsStr, 10, 64); err != nil || now.UnixMilli()-ts < 1500 {
-			score -= 3
-			reasons = append(reasons, "too_fast_submit")
+	// 2b. Allowed scripts (Config.Scripts, per-route overrides, or the legacy latin_only flag)
+	if policy, legacy := c.scriptPolicy(r); policy != nil {
+		if found := c.unexpectedScripts(r, policy); len(found) > 0 {
+			if legacy {
+				reasons = append(reasons, "non_latin_detected")
+			}
+			for _, name := range found {
+				reasons = append(reasons, "unexpected_script:"+name)
+			}
+			if policy.Penalty == 0 {
+				c.log(ip, ua, score, reasons)
+				return true, score
+			}
+			score -= policy.Penalty
 		}
-	} else {
-		score -= 3
-		reasons = append(reasons, "missing_ts")
 	}
 
-	// 4. JS token
-	if r.FormValue("js_token") != "set_by_js" {
-		score -= 2
-		reasons = append(reasons, "missing_js_token")
+	// 2c. Bundled X-GoCaptcha-Token header (fetch/XHR clients), spent once; the fields of
+	// a fresh token are read via captchaValue
+	if tok, pen, why, block := c.checkToken(r, now); block {
+		reasons = append(reasons, why)
+		c.log(ip, ua, score, reasons)
+		return true, score
+	} else if why != "" {
+		score += pen
+		reasons = append(reasons, why)
+	} else if tok != nil {
+		r = withToken(r, tok)
 	}
 
-	// 5. Behavior tracking
-	if ok, why := c.checkBehavior(r.FormValue("behavior_data")); !ok {
-		score -= 3
-		if why != "" {
-			reasons = append(reasons, "behavior:"+why)
+	// 2d. Signed per-form token from the template helpers / InjectHTML, spent once
+	if pen, why, block := c.checkFormToken(r, now); block {
+		reasons = append(reasons, why)
+		c.log(ip, ua, score, reasons)
+		return true, score
+	} else if why != "" {
+		score += pen
+		reasons = append(reasons, why)
+	}
+
+	// 2e. Clearance cookie from an earlier confident pass (Config.Clearance): a bonus,
+	// and the client-side signals below (timestamp, JS token, behavior, model) are skipped
+	cleared := false
+	if pen, why := c.checkClearance(r); why != "" {
+		score += pen
+		reasons = append(reasons, why)
+		cleared = pen > 0
+	}
+
+	if !cleared {
+		// 3. Timestamp (client JS writes current time in ms)
+		if tsStr := c.captchaValue(r, "ts"); tsStr != "" {
+			if ts, err := strconv.ParseInt(tsStr, 10, 64); err != nil || now.UnixMilli()-ts < 1500 {
+				score -= 3
+				reasons = append(reasons, "too_fast_submit")
+			}
 		} else {
-			reasons = append(reasons, "behavior_invalid")
+			score -= 3
+			reasons = append(reasons, "missing_ts")
+		}
+
+		// 4. JS token ("set_by_js", or derived from ts with RotateFields)
+		if !c.validJSToken(c.captchaValue(r, "ts"), c.captchaValue(r, "js_token"), now) {
+			score -= 2
+			reasons = append(reasons, "missing_js_token")
+		}
+
+		// 5. Behavior tracking
+		if ok, why := c.checkBehavior(c.captchaValue(r, "behavior_data")); !ok {
+			score -= 3
+			if why != "" {
+				reasons = append(reasons, "behavior:"+why)
+			} else {
+				reasons = append(reasons, "behavior_invalid")
+			}
 		}
 	}
 
@@ -201,15 +526,7 @@ func (c *Captcha) CheckRequest(r *http.Request) bool {
 	}
 
 	// 7. Headless/User-Agent indicators
-	if strings.Contains(ua, "HeadlessChrome") ||
-		strings.Contains(ua, "PhantomJS") ||
-		strings.Contains(ua, "SlimerJS") ||
-		strings.Contains(ua, "Electron") ||
-		strings.Contains(ua, "Puppeteer") ||
-		ua == "" ||
-		strings.Contains(ua, "Go-http-client") ||
-		strings.Contains(ua, "curl") ||
-		strings.Contains(ua, "python-requests") {
+	if isScriptedUA(ua) {
 		score -= 4
 		reasons = append(reasons, "headless_or_scripted_ua")
 	}
@@ -229,14 +546,10 @@ func (c *Captcha) CheckRequest(r *http.Request) bool {
 		reasons = append(reasons, "missing_sec_fetch_headers")
 	}
 
-	// 8. JS cookie detection
-	jsCookie, err := r.Cookie("js_captcha")
-	if errors.Is(http.ErrNoCookie, err) {
-		score -= 3
-		reasons = append(reasons, "missing_js_cookie")
-	} else if err != nil || jsCookie.Value != "enabled" {
-		score -= 2
-		reasons = append(reasons, "bad_js_cookie")
+	// 8. JS cookie detection (signed with SignedJSCookie)
+	if pen, why := c.checkJSCookie(r); why != "" {
+		score += pen
+		reasons = append(reasons, why)
 	}
 
 	// 9. Form content heuristics (names/messages/links)
@@ -245,28 +558,42 @@ func (c *Captcha) CheckRequest(r *http.Request) bool {
 		reasons = append(reasons, extra...)
 	}
 
+	// 10. Trained behavior model (optional; skipped with a clearance)
+	if !cleared {
+		if delta, why := c.scoreModel(r, now, len(recent)); delta != 0 || why != "" {
+			score += delta
+			if why != "" {
+				reasons = append(reasons, why)
+			}
+		}
+	}
+
 	blocked := score <= c.threshold()
 	c.log(ip, ua, score, reasons)
-	return blocked
+	return blocked, score
+}
+
+// isScriptedUA reports whether the User-Agent belongs to a headless browser or HTTP library.
+func isScriptedUA(ua string) bool {
+	return strings.Contains(ua, "HeadlessChrome") ||
+		strings.Contains(ua, "PhantomJS") ||
+		strings.Contains(ua, "SlimerJS") ||
+		strings.Contains(ua, "Electron") ||
+		strings.Contains(ua, "Puppeteer") ||
+		ua == "" ||
+		strings.Contains(ua, "Go-http-client") ||
+		strings.Contains(ua, "curl") ||
+		strings.Contains(ua, "python-requests")
 }
 
 func (c *Captcha) HoneypotField() string {
 	return c.fieldName
 }
 
+// BadgeHTML renders the badge (see Config.Badge) in the default language, or
+// returns "" unless ShowBadge is set. Use BadgeHTMLFor to localize it.
 func (c *Captcha) BadgeHTML() string {
-	if !c.cfg.ShowBadge {
-		return ""
-	}
-	msg := c.cfg.BadgeMessage
-	if strings.TrimSpace(msg) == "" {
-		msg = "Protected by GoCaptcha"
-	}
-	// Minimal, modern, non-intrusive floating badge with a lock icon
-	return `<div style="position:fixed;right:12px;bottom:12px;z-index:2147483647;display:inline-flex;align-items:center;gap:6px;background:rgba(17,17,17,.72);color:#fff;padding:6px 10px;border-radius:999px;backdrop-filter:saturate(150%) blur(6px);box-shadow:0 2px 10px rgba(0,0,0,.2);font:12px/1 system-ui,-apple-system,Segoe UI,Roboto,Arial,Helvetica,sans-serif;">` +
-		`<svg xmlns="http://www.w3.org/2000/svg" width="14" height="14" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round" aria-hidden="true"><rect x="3" y="11" width="18" height="10" rx="2" ry="2"/><path d="M7 11V7a5 5 0 0 1 10 0v4"/></svg>` +
-		`<span>` + msg + `</span>` +
-		`</div>`
+	return c.BadgeHTMLFor(nil)
 }
 
 // threshold returns the configured blocking threshold with backward compatibility.
@@ -278,10 +605,14 @@ func (c *Captcha) threshold() int {
 }
 
 // clientIP returns the best-effort client IP for this request.
-// If TrustProxyHeaders is enabled, it will try standard reverse-proxy headers
-// in this order: Forwarded (RFC 7239), X-Forwarded-For, X-Real-IP.
+// With TrustedProxies, see trustedClientIP. Otherwise, if TrustProxyHeaders is
+// enabled, it will try standard reverse-proxy headers in this order:
+// Forwarded (RFC 7239), X-Forwarded-For (left-most), X-Real-IP, CF-Connecting-IP.
 // Falls back to r.RemoteAddr if none are present/valid.
 func (c *Captcha) clientIP(r *http.Request) string {
+	if len(c.cfg.TrustedProxies) > 0 {
+		return c.trustedClientIP(r)
+	}
 	// Start with RemoteAddr
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
@@ -309,7 +640,7 @@ func (c *Captcha) clientIP(r *http.Request) string {
 				}
 				v := strings.TrimSpace(kv[1])
 				v = strings.Trim(v, "\"") // strip quotes
-				v = strings.Trim(v, "[]")   // strip IPv6 brackets if present
+				v = strings.Trim(v, "[]") // strip IPv6 brackets if present
 				// Might include port
 				if h, _, err := net.SplitHostPort(v); err == nil {
 					v = h
@@ -369,7 +700,50 @@ func (c *Captcha) log(ip, ua string, score int, reasons []string) {
 		return
 	}
 	b, _ := json.Marshal(reasons)
-	_, _ = c.db.Exec(`INSERT INTO captcha_logs (ip, ua, score, details) VALUES (?, ?, ?, ?)`, ip, ua, score, string(b))
+	_, _ = c.db.Exec(`INSERT INTO captcha_logs (ip, ua, score, details, ip_prefix) VALUES (?, ?, ?, ?, ?)`, ip, ua, score, string(b), c.ipNetwork(ip))
+}
+
+// backfillIPPrefixes sets ip_prefix on log rows written before the column existed.
+func (c *Captcha) backfillIPPrefixes() {
+	rows, err := c.db.Query(`SELECT DISTINCT ip FROM captcha_logs WHERE ip_prefix IS NULL AND ip <> ''`)
+	if err != nil {
+		return
+	}
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if rows.Scan(&ip) == nil {
+			ips = append(ips, ip)
+		}
+	}
+	rows.Close()
+	for _, ip := range ips {
+		_, _ = c.db.Exec(`UPDATE captcha_logs SET ip_prefix = ? WHERE ip = ? AND ip_prefix IS NULL`, c.ipNetwork(ip), ip)
+	}
+}
+
+// behaviorEvent is a single input event recorded by the frontend script.
+type behaviorEvent struct {
+	X     int   `json:"x"`
+	Y     int   `json:"y"`
+	T     int64 `json:"t"`
+	Key   bool  `json:"key,omitempty"`
+	Click bool  `json:"click,omitempty"`
+}
+
+var errBehaviorDecode = errors.New("behavior decode error")
+
+// decodeBehavior decodes the base64 JSON event list posted in behavior_data.
+func decodeBehavior(encoded string) ([]behaviorEvent, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(decoded) == 0 {
+		return nil, errBehaviorDecode
+	}
+	var events []behaviorEvent
+	if err := json.Unmarshal(decoded, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
 }
 
 // checkBehavior validates basic human-like input behavior encoded from the frontend.
@@ -378,17 +752,11 @@ func (c *Captcha) checkBehavior(encoded string) (bool, string) {
 	if encoded == "" {
 		return false, "missing_behavior"
 	}
-	decoded, err := base64.StdEncoding.DecodeString(encoded)
-	if err != nil || len(decoded) == 0 {
+	events, err := decodeBehavior(encoded)
+	if err == errBehaviorDecode {
 		return false, "behavior_decode_error"
 	}
-	type Event struct {
-		X int   `json:"x"`
-		Y int   `json:"y"`
-		T int64 `json:"t"`
-	}
-	var events []Event
-	if err := json.Unmarshal(decoded, &events); err != nil || len(events) < 5 {
+	if err != nil || len(events) < 5 {
 		return false, "behavior_not_enough_events"
 	}
 	// timestamps must be strictly increasing
@@ -433,34 +801,40 @@ func (c *Captcha) checkBehavior(encoded string) (bool, string) {
 	return true, ""
 }
 
+var (
+	urlRe   = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+	emailRe = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
 // analyzeFormContent inspects typical text fields (name, message, etc.) for spammy traits.
 // Returns a score delta (negative for penalties) and a list of reasons.
 func (c *Captcha) analyzeFormContent(r *http.Request) (int, []string) {
 	delta := 0
 	reasons := []string{}
 
-	fields := map[string]string{}
-	for k := range r.Form {
-		v := r.FormValue(k)
-		kl := strings.ToLower(k)
-		if kl == "name" || kl == "full_name" || kl == "fullname" || kl == "username" {
-			fields["name"] = v
-		} else if kl == "email" {
-			fields["email"] = v
-		} else if kl == "website" || kl == "url" || kl == "site" {
-			fields["website"] = v
-		} else if kl == "message" || kl == "msg" || kl == "comment" || kl == "content" || kl == "bio" || kl == "body" {
-			fields["message"] += "\n" + v
+	// Invisible/confusable characters anywhere in the submission (checked on raw values)
+	var raw []string
+	for k, vals := range r.Form {
+		if !c.isHoneypot(r, k) {
+			raw = append(raw, vals...)
 		}
 	}
+	if pen, extra := c.scoreObfuscation(raw); pen != 0 {
+		delta += pen
+		reasons = append(reasons, extra...)
+	}
 
-	msg := strings.TrimSpace(fields["message"])
-	name := strings.TrimSpace(fields["name"])
-	email := strings.TrimSpace(fields["email"])
-	website := strings.TrimSpace(fields["website"])
+	fields := c.formFields(r)
+	msg := strings.TrimSpace(fields[RoleMessage])
+	name := strings.TrimSpace(fields[RoleName])
+	email := strings.TrimSpace(fields[RoleEmail])
+	website := strings.TrimSpace(fields[RoleURL])
+	phone := strings.TrimSpace(fields[RolePhone])
+	subject := strings.TrimSpace(fields[RoleSubject])
+	// Keyword and classifier checks also cover the subject line
+	text := strings.TrimSpace(subject + "\n" + msg)
 
 	// URLs in message
-	urlRe := regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
 	links := urlRe.FindAllString(msg, -1)
 	if n := len(links); n > 0 {
 		pen := -2 - int(math.Min(float64(n-1), 2)) // -2 first, then -1 up to -4
@@ -468,26 +842,46 @@ func (c *Captcha) analyzeFormContent(r *http.Request) (int, []string) {
 		reasons = append(reasons, "links_in_message:"+strconv.Itoa(n))
 	}
 
-	// DB-configurable spammy keywords
-	kws := c.getSpamKeywords()
-	if len(kws) > 0 {
-		parts := make([]string, 0, len(kws))
-		for _, kw := range kws {
-			kw = strings.TrimSpace(kw)
-			if kw == "" {
-				continue
+	// DB-configurable spammy keywords (whole words/phrases, matcher cached until the table changes).
+	// Weights of distinct hits are summed up to KeywordPenaltyCap; reasons name each category.
+	if matched := c.matchKeywords(text); len(matched) > 0 {
+		pen := 0
+		seenCat := map[string]bool{}
+		for _, kw := range matched {
+			pen += kw.Weight
+			if !seenCat[kw.Category] {
+				seenCat[kw.Category] = true
+				if kw.Category == "" {
+					reasons = append(reasons, "spam_keywords")
+				} else {
+					reasons = append(reasons, "spam_keywords:"+kw.Category)
+				}
 			}
-			parts = append(parts, regexp.QuoteMeta(kw))
 		}
-		if len(parts) > 0 {
-			kwRe := regexp.MustCompile("(?i)(" + strings.Join(parts, "|") + ")")
-			if kwRe.MatchString(msg) {
-				delta -= 3
-				reasons = append(reasons, "spam_keywords")
+		if pen > c.cfg.KeywordPenaltyCap {
+			pen = c.cfg.KeywordPenaltyCap
+		}
+		delta -= pen
+		for i, kw := range matched {
+			if i == 5 {
+				break
 			}
+			reasons = append(reasons, "keyword:"+kw.Keyword)
 		}
 	}
 
+	// Learned spam probability (naive Bayes, trained via Train)
+	if pen, why := c.scoreBayes(text); pen != 0 {
+		delta += pen
+		reasons = append(reasons, why)
+	}
+
+	// Language of the message (detected language is always logged as lang:<code>)
+	if pen, extra := c.scoreLanguage(r, msg); len(extra) > 0 {
+		delta += pen
+		reasons = append(reasons, extra...)
+	}
+
 	// Emoji overuse
 	emojiCount := 0
 	for _, r := range msg {
@@ -521,12 +915,23 @@ func (c *Captcha) analyzeFormContent(r *http.Request) (int, []string) {
 	}
 
 	// Basic email validation
-	emailRe := regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
 	if email != "" && !emailRe.MatchString(email) {
 		delta -= 1
 		reasons = append(reasons, "email_invalid")
 	}
 
+	// Phone must look like a phone number if provided
+	if phone != "" && !validPhone(phone) {
+		delta -= 1
+		reasons = append(reasons, "phone_invalid")
+	}
+
+	// Links in the subject line
+	if subject != "" && urlRe.MatchString(subject) {
+		delta -= 1
+		reasons = append(reasons, "links_in_subject")
+	}
+
 	// Very short message with link is suspicious
 	if utf8.RuneCountInString(msg) < 15 && len(links) > 0 {
 		delta -= 1
@@ -550,75 +955,58 @@ func (c *Captcha) getConfigBool(key string, def bool) bool {
 	return s == "1" || s == "true" || s == "yes" || s == "on"
 }
 
-// defaultKeywords returns a seed list of common spammy tokens/phrases.
-func defaultKeywords() []string {
-	return []string{
-		"earn", "money", "cash", "crypto", "bitcoin", "forex", "seo", "backlink", "guest post",
-		"sponsor", "telegram", "whatsapp", "casino", "bet", "loan", "payday", "work from home",
-		"adult", "porn", "viagra", "sex", "xxx", "escort", "nft", "investment", "binary options",
-		"cheap", "discount", "limited offer", "promo", "marketing", "followers", "likes",
+// defaultKeywords returns a seed list of common spammy tokens/phrases with categories.
+func defaultKeywords() []Keyword {
+	seeds := []struct {
+		category string
+		weight   int
+		words    []string
+	}{
+		{"finance", 2, []string{"earn", "money", "cash", "forex", "loan", "payday", "work from home", "investment", "binary options"}},
+		{"crypto", 3, []string{"crypto", "bitcoin", "nft"}},
+		{"seo", 3, []string{"seo", "backlink", "guest post", "sponsor"}},
+		{"messenger", 2, []string{"telegram", "whatsapp"}},
+		{"gambling", 3, []string{"casino", "bet"}},
+		{"adult", 4, []string{"adult", "porn", "sex", "xxx", "escort"}},
+		{"pharma", 4, []string{"viagra"}},
+		{"marketing", 1, []string{"cheap", "discount", "limited offer", "promo", "marketing", "followers", "likes"}},
+	}
+	var out []Keyword
+	for _, s := range seeds {
+		for _, w := range s.words {
+			out = append(out, Keyword{Keyword: w, Weight: s.weight, Category: s.category})
+		}
 	}
+	return out
 }
 
-// getSpamKeywords returns the keywords from DB if available, otherwise seeds.
-func (c *Captcha) getSpamKeywords() []string {
+// getSpamKeywords returns the keywords from DB if available. An empty table
+// means no keywords; the seeds are only a fallback when the table cannot be
+// read.
+func (c *Captcha) getSpamKeywords() []Keyword {
 	if c.db == nil {
-		return defaultKeywords()
+		c.kwMemMu.RLock()
+		defer c.kwMemMu.RUnlock()
+		return append([]Keyword(nil), c.kwMem...)
 	}
-	rows, err := c.db.Query(`SELECT keyword FROM spam_keywords`)
+	rows, err := c.db.Query(`SELECT keyword, weight, category, is_regex FROM spam_keywords`)
 	if err != nil {
 		return defaultKeywords()
 	}
 	defer rows.Close()
-	var out []string
+	var out []Keyword
 	for rows.Next() {
-		var kw string
-		if err := rows.Scan(&kw); err == nil {
+		var kw Keyword
+		if err := rows.Scan(&kw.Keyword, &kw.Weight, &kw.Category, &kw.Regex); err == nil {
 			out = append(out, kw)
 		}
 	}
-	if len(out) == 0 {
+	if rows.Err() != nil {
 		return defaultKeywords()
 	}
 	return out
 }
 
-// isLatinOnlyText returns false if the string contains any Letter/Mark not in the Latin unicode script.
-func isLatinOnlyText(s string) bool {
-	for _, r := range s {
-		if unicode.IsLetter(r) || unicode.IsMark(r) {
-			if !unicode.Is(unicode.Latin, r) {
-				return false
-			}
-		}
-	}
-	return true
-}
-
-// formIsLatinOnly validates all form values (excluding the hidden extra field) for Latin-only letters.
-func (c *Captcha) formIsLatinOnly(r *http.Request) bool {
-	for k, vals := range r.Form {
-		if k == c.fieldName {
-			continue
-		}
-		for _, v := range vals {
-			if !isLatinOnlyText(v) {
-				return false
-			}
-		}
-	}
-	return true
-}
-
-func randSeq(n int) string {
-	letters := []rune("abcdefghijklmnopqrstuvwxyz0123456789")
-	s := make([]rune, n)
-	for i := range s {
-		s[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(s)
-}
-
 // shouldBypass returns true and a reason if the request should bypass CAPTCHA checks (e.g., OAuth callbacks).
 func (c *Captcha) shouldBypass(r *http.Request) (bool, string) {
 	// Custom predicate provided by integrator
@@ -668,6 +1056,14 @@ type StatIP struct {
 	Count int
 }
 
+// StatPrefix represents an IP network (see Config.IPv6Prefix) with its
+// occurrence count and the number of distinct addresses seen in it.
+type StatPrefix struct {
+	Prefix    string
+	Count     int
+	Addresses int
+}
+
 // StatUA represents a User-Agent with its occurrence count in logs.
 type StatUA struct {
 	UserAgent string
@@ -721,6 +1117,40 @@ func (c *Captcha) TopIPs(limit int, spamOnly bool) ([]StatIP, error) {
 	return out, rows.Err()
 }
 
+// TopPrefixes is TopIPs grouped by network (Config.IPv4Prefix/IPv6Prefix at
+// the time each row was logged). Many addresses in one prefix point to a
+// client rotating through its IPv6 range.
+func (c *Captcha) TopPrefixes(limit int, spamOnly bool) ([]StatPrefix, error) {
+	if c.db == nil {
+		return nil, errors.New("storage not enabled")
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	if spamOnly {
+		rows, err = c.db.Query(`SELECT ip_prefix, COUNT(*) AS cnt, COUNT(DISTINCT ip) FROM captcha_logs WHERE ip_prefix <> '' AND score <= ? GROUP BY ip_prefix ORDER BY cnt DESC LIMIT ?`, c.threshold(), limit)
+	} else {
+		rows, err = c.db.Query(`SELECT ip_prefix, COUNT(*) AS cnt, COUNT(DISTINCT ip) FROM captcha_logs WHERE ip_prefix <> '' GROUP BY ip_prefix ORDER BY cnt DESC LIMIT ?`, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []StatPrefix{}
+	for rows.Next() {
+		var s StatPrefix
+		if err := rows.Scan(&s.Prefix, &s.Count, &s.Addresses); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
 // TopUserAgents returns the most frequent User-Agents seen in captcha_logs.
 // If spamOnly is true, only entries with score <= current threshold are included.
 func (c *Captcha) TopUserAgents(limit int, spamOnly bool) ([]StatUA, error) {
@@ -923,11 +1353,13 @@ var embeddedJS embed.FS
 //
 //	/static/js/gocaptcha.js
 //
-// is reachable by the browser.
+// is reachable by the browser. The versioned name from ScriptFile()
+// (/static/js/gocaptcha.<hash>.js) is served too, with immutable caching.
+// Responses carry an ETag and Last-Modified, answer conditional requests with
+// 304 and are gzip-compressed when the client accepts it. Versioned names
+// with another hash get 404.
 func JSHandler() http.Handler {
-	// Read the embedded JS once and serve it with an explicit JS content type.
-	data, err := embeddedJS.ReadFile("static/js/gocaptcha.js")
-	if err != nil {
+	if embeddedScript.data == nil {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "GoCaptcha JS not available", http.StatusInternalServerError)
 		})
@@ -935,17 +1367,12 @@ func JSHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// After StripPrefix, accept either "gocaptcha.js" or "js/gocaptcha.js" to be forgiving.
 		p := strings.TrimLeft(r.URL.Path, "/")
-		if p != "" && !strings.HasSuffix(p, "gocaptcha.js") {
+		name := path.Base(p)
+		if p != "" && !isScriptName(name) {
 			http.NotFound(w, r)
 			return
 		}
-		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		if r.Method == http.MethodHead {
-			// For HEAD, only send headers.
-			return
-		}
-		_, _ = w.Write(data)
+		serveScript(w, r, name)
 	})
 }
 
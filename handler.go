@@ -0,0 +1,111 @@
+package gocaptcha
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ScriptConfig is the configuration the embedded script reads from
+// window.GoCaptchaConfig. data-gocaptcha-* attributes on the script tag
+// override it.
+type ScriptConfig struct {
+	NonceURL  string `json:"nonceURL"`            // where GoCaptcha.getToken() fetches nonces
+	Auto      bool   `json:"auto"`                // instrument every form (false: only forms with data-gocaptcha)
+	CookieURL string `json:"cookieURL,omitempty"` // signed JS cookie endpoint (SignedJSCookie)
+}
+
+// defaultScriptConfig is what the script assumes without any configuration.
+var defaultScriptConfig = ScriptConfig{NonceURL: "/gocaptcha/nonce", Auto: true}
+
+// ScriptConfig returns the script configuration for this Captcha.
+func (c *Captcha) ScriptConfig() ScriptConfig {
+	sc := ScriptConfig{
+		NonceURL: c.endpoint("nonce"),
+		Auto:     !c.cfg.ManualRender,
+	}
+	if c.cfg.SignedJSCookie {
+		sc.CookieURL = c.endpoint("cookie")
+	}
+	return sc
+}
+
+// ScriptConfigJS returns a JavaScript statement assigning the script
+// configuration to window.GoCaptchaConfig. Include it (inline or via the
+// config.js endpoint of Handler) before gocaptcha.js.
+func (c *Captcha) ScriptConfigJS() string {
+	b, _ := json.Marshal(c.ScriptConfig())
+	return "window.GoCaptchaConfig=" + string(b) + ";"
+}
+
+// endpoint returns the URL of a resource served by Handler.
+func (c *Captcha) endpoint(name string) string {
+	return strings.TrimRight(c.cfg.EndpointPath, "/") + "/" + name
+}
+
+// Handler serves the library's endpoints. Mount it at Config.EndpointPath
+// (default "/gocaptcha/"):
+//
+//	http.Handle("/gocaptcha/", cap.Handler())
+//
+// It serves:
+//
+//	badge.css     BadgeCSS(), for BadgeOptions.ClassMode
+//	gocaptcha.css badge and honeypot styles, for Config.ExternalStyles
+//	config.js     window.GoCaptchaConfig for the embedded script
+//	gocaptcha.js  the embedded script, also as ScriptFile() (see JSHandler); set
+//	              Config.ScriptPath to EndpointPath+"gocaptcha.js" to use it
+//	              from the template helpers. With RotateFields this is the
+//	              per-epoch build
+//	nonce         fresh nonces for GoCaptcha.getToken() (see NonceHandler)
+//	cookie        sets the signed JS cookie (see JSCookieHandler)
+//	TrapPath()    the trap link with Config.Traps (see TrapHandler)
+func (c *Captcha) Handler() http.Handler {
+	nonce := c.NonceHandler()
+	jsCookie := c.JSCookieHandler()
+	js := JSHandler()
+	trap := c.TrapHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path.Base(r.URL.Path) {
+		case "config.js":
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("Cache-Control", "no-cache")
+			if r.Method == http.MethodHead {
+				return
+			}
+			_, _ = w.Write([]byte(c.ScriptConfigJS()))
+		case "badge.css", "gocaptcha.css":
+			w.Header().Set("Content-Type", "text/css; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("Cache-Control", "no-cache")
+			if r.Method == http.MethodHead {
+				return
+			}
+			css := BadgeCSS()
+			if path.Base(r.URL.Path) == "gocaptcha.css" {
+				css = c.stylesheet()
+			}
+			_, _ = w.Write([]byte(css))
+		case "nonce":
+			nonce.ServeHTTP(w, r)
+		case "cookie":
+			jsCookie.ServeHTTP(w, r)
+		default:
+			if c.cfg.Traps && path.Base(r.URL.Path) == path.Base(c.TrapPath()) {
+				trap.ServeHTTP(w, r)
+				return
+			}
+			if isScriptName(path.Base(r.URL.Path)) {
+				if c.cfg.RotateFields {
+					c.serveRotatedScript(w, r)
+					return
+				}
+				js.ServeHTTP(w, r)
+				return
+			}
+			http.NotFound(w, r)
+		}
+	})
+}
@@ -0,0 +1,404 @@
+package gocaptcha
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// maxPendingTag bounds how much of an unterminated tag the injector buffers
+// across writes before giving up and passing it through unchanged.
+const maxPendingTag = 16 << 10
+
+// InjectHTML wraps next and rewrites its text/html responses on the fly:
+//
+//   - every <form method="post"> gets the honeypot and the hidden ts, js_token
+//     and behavior_data inputs right after its opening tag, and the decoys
+//     (Config.Decoys) not named like one of its own fields before </form>,
+//   - the script tag (Config.ScriptPath), BadgeHTMLFor(r) and, with Config.Traps,
+//     the hidden trap link (TrapLinkHTML) are added before </body>,
+//   - with Config.ExternalStyles, the gocaptcha.css link is added before </head>.
+//
+// Injected <script> and <style> elements carry the CSP nonce from
+// Config.CSPNonce or, failing that, the first 'nonce-...' source of the
+// response's Content-Security-Policy header.
+//
+// Forms with data-gocaptcha="off" are left alone; <body data-gocaptcha="off">
+// disables the script and badge for that page. The script tag is skipped when
+// the page already loads gocaptcha.js. Compressed responses are passed through
+// unchanged, so put InjectHTML inside (closer to the handler than) any gzip
+// middleware. The response is streamed; Content-Length is dropped.
+func (c *Captcha) InjectHTML(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		iw := &injectWriter{ResponseWriter: w, c: c, r: r}
+		next.ServeHTTP(iw, r)
+		iw.finish()
+	})
+}
+
+// injectWriter decides on the first WriteHeader/Write whether the response is
+// HTML and, if so, feeds the body through an htmlInjector. Like net/http, it
+// sniffs the first 512 bytes when the handler sets no Content-Type.
+type injectWriter struct {
+	http.ResponseWriter
+	c       *Captcha
+	r       *http.Request
+	status  int
+	sniff   []byte
+	decided bool
+	inj     *htmlInjector
+}
+
+func (iw *injectWriter) WriteHeader(status int) {
+	if status < 200 {
+		iw.ResponseWriter.WriteHeader(status) // informational, e.g. 103 Early Hints
+		return
+	}
+	if iw.status != 0 {
+		return
+	}
+	iw.status = status
+	if iw.Header().Get("Content-Type") != "" {
+		iw.start()
+	}
+}
+
+// start decides whether to rewrite and sends the header.
+func (iw *injectWriter) start() {
+	if iw.decided {
+		return
+	}
+	iw.decided = true
+	if iw.status == 0 {
+		iw.status = http.StatusOK
+	}
+	h := iw.Header()
+	if len(iw.sniff) > 0 && h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(iw.sniff))
+	}
+	ct, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if ct == "text/html" && h.Get("Content-Encoding") == "" &&
+		iw.status != http.StatusNoContent && iw.status != http.StatusNotModified {
+		h.Del("Content-Length")
+		nonce := iw.c.cspNonce(iw.r)
+		if nonce == "" {
+			nonce = nonceFromCSP(h.Get("Content-Security-Policy"))
+		}
+		iw.inj = &htmlInjector{w: iw.ResponseWriter, c: iw.c, r: iw.r, nonce: nonce}
+	}
+	iw.ResponseWriter.WriteHeader(iw.status)
+	if len(iw.sniff) > 0 {
+		_ = iw.write(iw.sniff)
+		iw.sniff = nil
+	}
+}
+
+func (iw *injectWriter) Write(p []byte) (int, error) {
+	if !iw.decided {
+		if iw.Header().Get("Content-Type") == "" && len(iw.sniff)+len(p) < 512 {
+			iw.sniff = append(iw.sniff, p...)
+			return len(p), nil
+		}
+		iw.sniff = append(iw.sniff, p...)
+		iw.start()
+		return len(p), nil
+	}
+	if err := iw.write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (iw *injectWriter) write(p []byte) error {
+	if iw.inj == nil {
+		_, err := iw.ResponseWriter.Write(p)
+		return err
+	}
+	return iw.inj.write(p)
+}
+
+// Flush sends what has been rewritten so far. A tag split across writes stays
+// buffered until it is complete.
+func (iw *injectWriter) Flush() {
+	if iw.status != 0 || len(iw.sniff) > 0 {
+		iw.start()
+	}
+	if f, ok := iw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets websocket and similar handlers work behind InjectHTML.
+func (iw *injectWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := iw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap returns the original writer (for http.ResponseController).
+func (iw *injectWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+func (iw *injectWriter) finish() {
+	if iw.status != 0 || len(iw.sniff) > 0 {
+		iw.start()
+	}
+	if iw.inj != nil {
+		_ = iw.inj.close()
+	}
+}
+
+// htmlInjector is a small streaming tag scanner. It does not build a DOM: it
+// copies the input through, recognizing complete tags, and skips the contents
+// of comments, <script>, <style>, <textarea> and <title>, where '<form' is not
+// markup.
+type htmlInjector struct {
+	w       io.Writer
+	c       *Captcha
+	r       *http.Request
+	nonce   string
+	pending []byte // unprocessed tail of the previous write
+	raw     string // lowercase terminator while inside raw text ("</script", "-->")
+
+	bodyDone   bool // script and badge written (or disabled)
+	scriptSeen bool // page already loads gocaptcha.js
+
+	formNames map[string]bool // field names of the open form, nil outside an injected form
+	formToken string          // gc_form_token of the open form
+}
+
+func (h *htmlInjector) write(p []byte) error {
+	data := append(h.pending, p...)
+	h.pending = nil
+	var out bytes.Buffer
+	i := 0
+	for i < len(data) {
+		if h.raw != "" {
+			idx := indexFold(data[i:], h.raw)
+			if idx < 0 {
+				// Keep enough bytes to recognize a terminator split across writes
+				safe := len(data) - (len(h.raw) - 1)
+				if safe > i {
+					out.Write(data[i:safe])
+					i = safe
+				}
+				break
+			}
+			end := i + idx
+			if h.raw == "-->" {
+				end += len(h.raw)
+			}
+			out.Write(data[i:end])
+			i = end
+			h.raw = ""
+			continue
+		}
+		lt := bytes.IndexByte(data[i:], '<')
+		if lt < 0 {
+			out.Write(data[i:])
+			i = len(data)
+			break
+		}
+		out.Write(data[i : i+lt])
+		i += lt
+		rest := data[i:]
+		if len(rest) < 4 && bytes.HasPrefix([]byte("<!--"), rest) {
+			break // maybe a comment; wait for more
+		}
+		if bytes.HasPrefix(rest, []byte("<!--")) {
+			out.WriteString("<!--")
+			i += 4
+			h.raw = "-->"
+			continue
+		}
+		gt := tagEnd(rest)
+		if gt < 0 {
+			if len(rest) > maxPendingTag {
+				out.Write(rest)
+				i = len(data)
+			}
+			break
+		}
+		h.tag(&out, rest[:gt+1])
+		i += gt + 1
+	}
+	if i < len(data) {
+		h.pending = append([]byte(nil), data[i:]...)
+	}
+	if out.Len() == 0 {
+		return nil
+	}
+	_, err := h.w.Write(out.Bytes())
+	return err
+}
+
+// tag writes one complete tag, with any injected markup.
+func (h *htmlInjector) tag(out *bytes.Buffer, tag []byte) {
+	name, attrs := parseTag(tag)
+	switch name {
+	case "form":
+		out.Write(tag)
+		if strings.EqualFold(attrs["method"], "post") && attrs["data-gocaptcha"] != "off" {
+			h.formToken = h.c.FormToken()
+			out.WriteString(h.c.formFieldsHTML(h.nonce, h.formToken))
+			h.formNames = map[string]bool{}
+		}
+		return
+	case "/form":
+		// Decoys go last, once the form's own field names are known
+		if h.formNames != nil {
+			out.WriteString(h.c.decoysHTML(h.formNames, h.formToken))
+			h.formNames = nil
+		}
+	case "input", "select", "button":
+		h.addFormName(attrs)
+	case "/head":
+		out.WriteString(h.c.stylesheetHTML(h.nonce))
+	case "body":
+		if attrs["data-gocaptcha"] == "off" {
+			h.bodyDone = true
+		}
+	case "/body":
+		if !h.bodyDone {
+			h.bodyDone = true
+			if !h.scriptSeen {
+				out.WriteString(h.c.scriptTagsHTML(h.nonce))
+			}
+			out.WriteString(h.c.badgeHTML(h.r, h.nonce))
+			out.WriteString(h.c.TrapLinkHTML())
+		}
+	case "script":
+		if strings.HasSuffix(strings.SplitN(attrs["src"], "?", 2)[0], "gocaptcha.js") {
+			h.scriptSeen = true
+		}
+		fallthrough
+	case "style", "textarea", "title":
+		if name == "textarea" {
+			h.addFormName(attrs)
+		}
+		if !bytes.HasSuffix(tag, []byte("/>")) {
+			h.raw = "</" + name
+		}
+	}
+	out.Write(tag)
+}
+
+// addFormName records the name of a field inside an injected form.
+func (h *htmlInjector) addFormName(attrs map[string]string) {
+	if h.formNames != nil && attrs["name"] != "" {
+		h.formNames[attrs["name"]] = true
+	}
+}
+
+// close flushes whatever is still buffered (e.g. a truncated tag).
+func (h *htmlInjector) close() error {
+	if len(h.pending) == 0 {
+		return nil
+	}
+	_, err := h.w.Write(h.pending)
+	h.pending = nil
+	return err
+}
+
+// tagEnd returns the index of the '>' closing the tag at the start of b,
+// ignoring '>' inside quoted attribute values, or -1.
+func tagEnd(b []byte) int {
+	var quote byte
+	for i := 1; i < len(b); i++ {
+		switch c := b[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseTag returns the lowercase name ("form", "/body") and attributes of a
+// complete tag. Attribute names are lowercased; values are unescaped.
+func parseTag(tag []byte) (string, map[string]string) {
+	s := string(tag[1 : len(tag)-1])
+	start := 0
+	if strings.HasPrefix(s, "/") {
+		start = 1 // closing tag
+	}
+	n := strings.IndexAny(s[start:], " \t\n\r\f/")
+	if n < 0 {
+		n = len(s)
+	} else {
+		n += start
+	}
+	name := strings.ToLower(s[:n])
+	attrs := map[string]string{}
+	s = s[n:]
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f/")
+		if s == "" {
+			break
+		}
+		k := strings.IndexAny(s, " \t\n\r\f/=")
+		if k < 0 {
+			k = len(s)
+		}
+		key := strings.ToLower(s[:k])
+		s = strings.TrimLeft(s[k:], " \t\n\r\f")
+		val := ""
+		if strings.HasPrefix(s, "=") {
+			s = strings.TrimLeft(s[1:], " \t\n\r\f")
+			if s != "" && (s[0] == '"' || s[0] == '\'') {
+				q := s[0]
+				if e := strings.IndexByte(s[1:], q); e >= 0 {
+					val, s = s[1:1+e], s[2+e:]
+				} else {
+					val, s = s[1:], ""
+				}
+			} else {
+				e := strings.IndexAny(s, " \t\n\r\f")
+				if e < 0 {
+					e = len(s)
+				}
+				val, s = s[:e], s[e:]
+			}
+		}
+		if _, dup := attrs[key]; !dup && key != "" {
+			attrs[key] = html.UnescapeString(val)
+		}
+	}
+	return name, attrs
+}
+
+// indexFold is a case-insensitive bytes.Index for an ASCII lowercase needle.
+func indexFold(b []byte, lower string) int {
+	for i := 0; i+len(lower) <= len(b); i++ {
+		j := 0
+		for ; j < len(lower); j++ {
+			c := b[i+j]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			if c != lower[j] {
+				break
+			}
+		}
+		if j == len(lower) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,88 @@
+package gocaptcha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jsCookieBinding is what signed JS and clearance cookies are bound to: the
+// client's network (see ipNetwork, Config.IPv4Prefix and IPv6Prefix) and a
+// hash of its User-Agent.
+func (c *Captcha) jsCookieBinding(r *http.Request) string {
+	ua := sha256.Sum256([]byte(r.Header.Get("User-Agent")))
+	return c.ipNetwork(c.clientIP(r)) + "|" + b64(ua[:9])
+}
+
+// issueJSCookie returns a signed cookie value "exp.nonce.mac" bound to the
+// request's IP prefix and User-Agent.
+func (c *Captcha) issueJSCookie(r *http.Request) string {
+	body := strconv.FormatInt(time.Now().Add(c.cfg.JSCookieTTL).Unix(), 10) + "." + b64(randomBytes(12))
+	return body + "." + c.sign("jscookie", body+"|"+c.jsCookieBinding(r))
+}
+
+// verifyJSCookie checks a value from issueJSCookie against the request.
+func (c *Captcha) verifyJSCookie(r *http.Request, v string) error {
+	i := strings.LastIndexByte(v, '.')
+	if i <= 0 {
+		return errBadSignature
+	}
+	body, mac := v[:i], v[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(c.sign("jscookie", body+"|"+c.jsCookieBinding(r)))) {
+		return errBadSignature
+	}
+	exp, err := strconv.ParseInt(strings.SplitN(body, ".", 2)[0], 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	if time.Now().Unix() > exp {
+		return errExpired
+	}
+	return nil
+}
+
+// JSCookieHandler sets the signed JS cookie (Config.SignedJSCookie). The
+// script requests it on load; Handler serves it as "cookie". The cookie is
+// HttpOnly, so page scripts cannot read or forge it.
+func (c *Captcha) JSCookieHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secure := c.forwardedHTTPS(r)
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.currentFields().Cookie,
+			Value:    c.issueJSCookie(r),
+			Path:     "/",
+			MaxAge:   int(c.cfg.JSCookieTTL / time.Second),
+			HttpOnly: true,
+			Secure:   secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// checkJSCookie scores the JS cookie: missing, or (with SignedJSCookie)
+// forged/expired, or (without) anything but "enabled".
+func (c *Captcha) checkJSCookie(r *http.Request) (int, string) {
+	ck, err := c.jsCookie(r)
+	if err != nil {
+		return -3, "missing_js_cookie"
+	}
+	if !c.cfg.SignedJSCookie {
+		if ck.Value != "enabled" {
+			return -2, "bad_js_cookie"
+		}
+		return 0, ""
+	}
+	switch err := c.verifyJSCookie(r, ck.Value); {
+	case errors.Is(err, errExpired):
+		return -2, "expired_js_cookie"
+	case err != nil:
+		return -2, "bad_js_cookie"
+	}
+	return 0, ""
+}
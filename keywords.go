@@ -0,0 +1,513 @@
+package gocaptcha
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Keyword is a spam keyword or phrase stored in the spam_keywords table.
+type Keyword struct {
+	Keyword  string
+	Weight   int    // Penalty points per hit (3 when unset).
+	Category string // Free-form group such as pharma, crypto, seo or adult; used in reasons.
+	Regex    bool   // Keyword is a regular expression, matched case-insensitively instead of as a whole word.
+}
+
+// keywordMatcher is an Aho-Corasick automaton over lowercased runes with
+// whole-word semantics: a keyword only matches when it is not directly
+// preceded or followed by a letter or digit, so "bet" does not match "better".
+// Phrases match across any run of whitespace ("guest   post").
+type keywordMatcher struct {
+	nodes []acNode
+	kws   []acKeyword
+}
+
+type acNode struct {
+	next map[rune]int
+	fail int
+	out  []int // indexes into keywords ending at this node
+}
+
+// acKeyword is a keyword as configured and the rune length of its normalized form.
+type acKeyword struct {
+	text string
+	n    int
+}
+
+type keywordMatch struct {
+	Keyword string
+	Index   int // index of the keyword in the list the matcher was built from
+}
+
+func newKeywordMatcher(keywords []string) *keywordMatcher {
+	m := &keywordMatcher{nodes: []acNode{{next: map[rune]int{}}}}
+	kws := make([]acKeyword, len(keywords))
+	for i, kw := range keywords {
+		norm := normalizeKeywordText(Skeleton(kw))
+		kws[i] = acKeyword{text: kw, n: len([]rune(norm))}
+		if norm == "" {
+			continue
+		}
+		cur := 0
+		for _, r := range norm {
+			nx, ok := m.nodes[cur].next[r]
+			if !ok {
+				nx = len(m.nodes)
+				m.nodes = append(m.nodes, acNode{next: map[rune]int{}})
+				m.nodes[cur].next[r] = nx
+			}
+			cur = nx
+		}
+		m.nodes[cur].out = append(m.nodes[cur].out, i)
+	}
+	// Breadth-first construction of failure links.
+	queue := []int{}
+	for _, nx := range m.nodes[0].next {
+		queue = append(queue, nx)
+	}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		for r, v := range m.nodes[u].next {
+			f := m.nodes[u].fail
+			for f != 0 {
+				if _, ok := m.nodes[f].next[r]; ok {
+					break
+				}
+				f = m.nodes[f].fail
+			}
+			if nx, ok := m.nodes[f].next[r]; ok && nx != v {
+				m.nodes[v].fail = nx
+			}
+			m.nodes[v].out = append(m.nodes[v].out, m.nodes[m.nodes[v].fail].out...)
+			queue = append(queue, v)
+		}
+	}
+	m.kws = kws
+	return m
+}
+
+// normalizeKeywordText lowercases s and collapses whitespace runs to one space.
+func normalizeKeywordText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// match returns every distinct keyword found in text as a whole word or phrase,
+// in order of first occurrence. Keywords and text are compared by Skeleton, so
+// lookalike letters, full-width forms and zero-width characters do not evade it.
+func (m *keywordMatcher) match(text string) []keywordMatch {
+	if m == nil || len(m.nodes) <= 1 {
+		return nil
+	}
+	runes := []rune(normalizeKeywordText(Skeleton(text)))
+	isWord := func(i int) bool {
+		return i >= 0 && i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+	}
+	var out []keywordMatch
+	seen := map[int]bool{}
+	cur := 0
+	for i, r := range runes {
+		for cur != 0 {
+			if _, ok := m.nodes[cur].next[r]; ok {
+				break
+			}
+			cur = m.nodes[cur].fail
+		}
+		if nx, ok := m.nodes[cur].next[r]; ok {
+			cur = nx
+		}
+		for _, k := range m.nodes[cur].out {
+			if seen[k] {
+				continue
+			}
+			start := i - m.kws[k].n + 1
+			if isWord(start-1) || isWord(i+1) {
+				continue
+			}
+			seen[k] = true
+			out = append(out, keywordMatch{Keyword: m.kws[k].text, Index: k})
+		}
+	}
+	return out
+}
+
+// keywordCache holds the compiled matcher and the keyword table version it was
+// built from. SQLite triggers bump captcha_config.keywords_version whenever
+// spam_keywords changes, so edits made with raw SQL are picked up too. Without
+// storage the keywords live in Captcha.kwMem.
+type keywordCache struct {
+	mu      sync.Mutex
+	set     *keywordSet
+	version string
+	loaded  bool
+}
+
+// keywordSet is the compiled form of the keyword table: plain keywords in an
+// Aho-Corasick matcher and regex keywords compiled individually.
+type keywordSet struct {
+	plain   []Keyword
+	matcher *keywordMatcher
+	regex   []Keyword
+	res     []*regexp.Regexp
+}
+
+func newKeywordSet(kws []Keyword) *keywordSet {
+	ks := &keywordSet{}
+	var words []string
+	for _, kw := range kws {
+		kw.Keyword = strings.TrimSpace(kw.Keyword)
+		if kw.Keyword == "" {
+			continue
+		}
+		if kw.Weight == 0 {
+			kw.Weight = 3
+		}
+		if kw.Regex {
+			re, err := regexp.Compile("(?i)" + kw.Keyword)
+			if err != nil {
+				continue // skip invalid patterns rather than failing every request
+			}
+			ks.regex = append(ks.regex, kw)
+			ks.res = append(ks.res, re)
+			continue
+		}
+		ks.plain = append(ks.plain, kw)
+		words = append(words, kw.Keyword)
+	}
+	ks.matcher = newKeywordMatcher(words)
+	return ks
+}
+
+// match returns the distinct keywords found in text: plain keywords in order of
+// first occurrence, followed by matching regex keywords.
+func (ks *keywordSet) match(text string) []Keyword {
+	var out []Keyword
+	for _, m := range ks.matcher.match(text) {
+		out = append(out, ks.plain[m.Index])
+	}
+	skel := Skeleton(text)
+	for i, re := range ks.res {
+		if re.MatchString(text) || re.MatchString(skel) {
+			out = append(out, ks.regex[i])
+		}
+	}
+	return out
+}
+
+// initKeywordStorage installs the triggers that version the keyword table.
+func (c *Captcha) initKeywordStorage() {
+	c.db.Exec(`INSERT OR IGNORE INTO captcha_config (key, value) VALUES ('keywords_version','1')`)
+	for _, ev := range []string{"INSERT", "UPDATE", "DELETE"} {
+		c.db.Exec(`CREATE TRIGGER IF NOT EXISTS spam_keywords_version_` + strings.ToLower(ev) + ` AFTER ` + ev + ` ON spam_keywords
+			BEGIN
+				UPDATE captcha_config SET value = CAST(value AS INTEGER) + 1 WHERE key = 'keywords_version';
+			END`)
+	}
+}
+
+// keywordsVersion returns the current keyword table version. Without storage
+// it is the in-memory version bumped by AddKeywords/RemoveKeywords.
+func (c *Captcha) keywordsVersion() string {
+	if c.db == nil {
+		c.kwMemMu.RLock()
+		defer c.kwMemMu.RUnlock()
+		return "mem:" + strconv.Itoa(c.kwMemVersion)
+	}
+	var v string
+	if err := c.db.QueryRow(`SELECT value FROM captcha_config WHERE key = 'keywords_version'`).Scan(&v); err != nil {
+		return ""
+	}
+	return v
+}
+
+// keywordSet returns the compiled keywords, rebuilding them when the keyword
+// table has changed since the last build.
+func (c *Captcha) keywordSet() *keywordSet {
+	v := c.keywordsVersion()
+	kc := &c.kwCache
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	if kc.loaded && kc.version == v {
+		return kc.set
+	}
+	kc.set = newKeywordSet(c.getSpamKeywords())
+	kc.version = v
+	kc.loaded = true
+	return kc.set
+}
+
+func (c *Captcha) matchKeywords(text string) []Keyword {
+	return c.keywordSet().match(text)
+}
+
+// MatchKeywords returns the spam keywords found in text as whole words or
+// phrases (or regex keywords that match), in order of first occurrence.
+func (c *Captcha) MatchKeywords(text string) []string {
+	matches := c.matchKeywords(text)
+	out := make([]string, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, m.Keyword)
+	}
+	return out
+}
+
+// normalizeKeyword trims a keyword, lowercases plain keywords and applies the default weight.
+func normalizeKeyword(kw Keyword) (Keyword, error) {
+	kw.Keyword = strings.TrimSpace(kw.Keyword)
+	kw.Category = strings.ToLower(strings.TrimSpace(kw.Category))
+	if kw.Keyword == "" {
+		return kw, errors.New("empty keyword")
+	}
+	if kw.Regex {
+		if _, err := regexp.Compile(kw.Keyword); err != nil {
+			return kw, fmt.Errorf("keyword %q: %w", kw.Keyword, err)
+		}
+	} else {
+		kw.Keyword = normalizeKeywordText(kw.Keyword)
+	}
+	if kw.Weight == 0 {
+		kw.Weight = 3
+	}
+	return kw, nil
+}
+
+// AddKeywords adds spam keywords, or updates weight/category/regex of existing
+// ones. Changes apply to the next checked request.
+func (c *Captcha) AddKeywords(kws ...Keyword) error {
+	norm := make([]Keyword, 0, len(kws))
+	for _, kw := range kws {
+		kw, err := normalizeKeyword(kw)
+		if err != nil {
+			return err
+		}
+		norm = append(norm, kw)
+	}
+	if len(norm) == 0 {
+		return nil
+	}
+	if c.db == nil {
+		c.kwMemMu.Lock()
+		defer c.kwMemMu.Unlock()
+		for _, kw := range norm {
+			replaced := false
+			for i := range c.kwMem {
+				if c.kwMem[i].Keyword == kw.Keyword {
+					c.kwMem[i] = kw
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				c.kwMem = append(c.kwMem, kw)
+			}
+		}
+		c.kwMemVersion++
+		return nil
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, kw := range norm {
+		if _, err := tx.Exec(`INSERT INTO spam_keywords (keyword, weight, category, is_regex) VALUES (?, ?, ?, ?)
+			ON CONFLICT(keyword) DO UPDATE SET weight = excluded.weight, category = excluded.category, is_regex = excluded.is_regex`,
+			kw.Keyword, kw.Weight, kw.Category, kw.Regex); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveKeywords deletes the given keywords. Unknown keywords are ignored.
+func (c *Captcha) RemoveKeywords(keywords ...string) error {
+	if c.db == nil {
+		c.kwMemMu.Lock()
+		defer c.kwMemMu.Unlock()
+		drop := map[string]bool{}
+		for _, k := range keywords {
+			drop[strings.TrimSpace(k)] = true
+			drop[normalizeKeywordText(k)] = true
+		}
+		kept := c.kwMem[:0]
+		for _, kw := range c.kwMem {
+			if !drop[kw.Keyword] {
+				kept = append(kept, kw)
+			}
+		}
+		c.kwMem = kept
+		c.kwMemVersion++
+		return nil
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, k := range keywords {
+		if _, err := tx.Exec(`DELETE FROM spam_keywords WHERE keyword IN (?, ?)`, strings.TrimSpace(k), normalizeKeywordText(k)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListKeywords returns all configured spam keywords sorted alphabetically.
+func (c *Captcha) ListKeywords() ([]Keyword, error) {
+	var out []Keyword
+	if c.db == nil {
+		c.kwMemMu.RLock()
+		out = append(out, c.kwMem...)
+		c.kwMemMu.RUnlock()
+	} else {
+		rows, err := c.db.Query(`SELECT keyword, weight, category, is_regex FROM spam_keywords`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var kw Keyword
+			if err := rows.Scan(&kw.Keyword, &kw.Weight, &kw.Category, &kw.Regex); err != nil {
+				return nil, err
+			}
+			out = append(out, kw)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Keyword < out[j].Keyword })
+	return out, nil
+}
+
+// ImportKeywords reads keywords from r and adds them (see AddKeywords). Two
+// formats are accepted and detected from the first non-comment line:
+//
+//   - a plain list with one keyword or phrase per line (ExportKeywordList)
+//   - CSV with columns keyword,weight,category,regex (all but keyword optional;
+//     an optional header row starting with "keyword" is skipped; ExportKeywords)
+//
+// The first line is CSV when it is that header or its second column is a
+// number or empty, so a plain phrase with a comma ("hello, world") stays a
+// plain keyword.
+//
+// Blank lines and lines starting with '#' are ignored. It returns the number
+// of keywords imported.
+func (c *Captcha) ImportKeywords(r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	var lines []string
+	for _, ln := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		t := strings.TrimSpace(ln)
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		lines = append(lines, ln)
+	}
+	if len(lines) == 0 {
+		return 0, nil
+	}
+
+	var kws []Keyword
+	if !isKeywordCSV(lines[0]) {
+		for _, ln := range lines {
+			kws = append(kws, Keyword{Keyword: ln})
+		}
+	} else {
+		cr := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		recs, err := cr.ReadAll()
+		if err != nil {
+			return 0, err
+		}
+		for i, rec := range recs {
+			if i == 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "keyword") {
+				continue
+			}
+			kw := Keyword{Keyword: rec[0]}
+			if len(rec) > 1 && strings.TrimSpace(rec[1]) != "" {
+				w, err := strconv.Atoi(strings.TrimSpace(rec[1]))
+				if err != nil {
+					return 0, fmt.Errorf("line %d: invalid weight %q", i+1, rec[1])
+				}
+				kw.Weight = w
+			}
+			if len(rec) > 2 {
+				kw.Category = rec[2]
+			}
+			if len(rec) > 3 {
+				switch strings.ToLower(strings.TrimSpace(rec[3])) {
+				case "1", "true", "yes", "regex":
+					kw.Regex = true
+				}
+			}
+			kws = append(kws, kw)
+		}
+	}
+	if err := c.AddKeywords(kws...); err != nil {
+		return 0, err
+	}
+	return len(kws), nil
+}
+
+// isKeywordCSV reports whether the first line of an import is CSV.
+func isKeywordCSV(line string) bool {
+	rec, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil || len(rec) < 2 {
+		return false
+	}
+	if strings.EqualFold(strings.TrimSpace(rec[0]), "keyword") {
+		return true
+	}
+	w := strings.TrimSpace(rec[1])
+	if w == "" {
+		return true
+	}
+	_, err = strconv.Atoi(w)
+	return err == nil
+}
+
+// ExportKeywordList writes the plain keywords to w, one per line, for
+// ImportKeywords or other tools. Weights and categories are not part of the
+// list and regex keywords are left out; use ExportKeywords for a full copy.
+func (c *Captcha) ExportKeywordList(w io.Writer) error {
+	kws, err := c.ListKeywords()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, kw := range kws {
+		if !kw.Regex {
+			_, _ = bw.WriteString(kw.Keyword + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// ExportKeywords writes all keywords to w as CSV (keyword,weight,category,regex)
+// with a header row. The output can be read back with ImportKeywords.
+func (c *Captcha) ExportKeywords(w io.Writer) error {
+	kws, err := c.ListKeywords()
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"keyword", "weight", "category", "regex"})
+	for _, kw := range kws {
+		_ = cw.Write([]string{kw.Keyword, strconv.Itoa(kw.Weight), kw.Category, strconv.FormatBool(kw.Regex)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
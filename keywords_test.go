@@ -0,0 +1,89 @@
+package gocaptcha
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// keywordCaptcha returns a captcha without storage holding exactly kws.
+func keywordCaptcha(t *testing.T, kws ...Keyword) *Captcha {
+	t.Helper()
+	c := New(Config{})
+	c.kwMem = nil
+	if err := c.AddKeywords(kws...); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestKeywordExportRoundTrip(t *testing.T) {
+	src := keywordCaptcha(t,
+		Keyword{Keyword: "free crypto", Weight: 4, Category: "crypto"},
+		Keyword{Keyword: "hello, world", Weight: 2},
+		Keyword{Keyword: `c[i1]alis`, Weight: 5, Category: "pharma", Regex: true},
+		Keyword{Keyword: "viagra"},
+	)
+	want, _ := src.ListKeywords()
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := src.ExportKeywords(&buf); err != nil {
+			t.Fatal(err)
+		}
+		dst := keywordCaptcha(t)
+		if _, err := dst.ImportKeywords(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := dst.ListKeywords(); !reflect.DeepEqual(got, want) {
+			t.Errorf("CSV round trip = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := src.ExportKeywordList(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "free crypto\nhello, world\nviagra\n" {
+			t.Errorf("ExportKeywordList = %q", buf.String())
+		}
+		dst := keywordCaptcha(t)
+		if n, err := dst.ImportKeywords(&buf); err != nil || n != 3 {
+			t.Fatalf("ImportKeywords = %d, %v, want 3", n, err)
+		}
+		var names []string
+		got, _ := dst.ListKeywords()
+		for _, kw := range got {
+			names = append(names, kw.Keyword)
+		}
+		if !reflect.DeepEqual(names, []string{"free crypto", "hello, world", "viagra"}) {
+			t.Errorf("list round trip = %v", names)
+		}
+	})
+}
+
+func TestRemovedKeywordsStayRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captcha.db")
+	c := New(Config{EnableStorage: true, DBPath: path})
+	kws, err := c.ListKeywords()
+	if err != nil || len(kws) == 0 {
+		t.Fatalf("ListKeywords = %d, %v, want the seeds", len(kws), err)
+	}
+	for _, kw := range kws {
+		if err := c.RemoveKeywords(kw.Keyword); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m := c.MatchKeywords("cheap viagra"); len(m) != 0 {
+		t.Errorf("MatchKeywords after removing all = %v, want none", m)
+	}
+	c.db.Close()
+
+	c = New(Config{EnableStorage: true, DBPath: path})
+	defer c.db.Close()
+	if kws, _ := c.ListKeywords(); len(kws) != 0 {
+		t.Errorf("%d keywords after restart, want 0", len(kws))
+	}
+}
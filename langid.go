@@ -0,0 +1,226 @@
+package gocaptcha
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LanguagePolicy flags messages that are not written in one of the site's
+// expected languages.
+type LanguagePolicy struct {
+	Expected  []string // ISO 639-1 codes, e.g. "en", "sr". Empty only records the detected language.
+	Penalty   int      // Score penalty for off-language messages. Defaults to 2.
+	MinLength int      // Minimum message length in runes before detection runs. Defaults to 40.
+}
+
+// langSamples are short representative texts used to build the trigram
+// profiles of the embedded language identifier (Cavnar & Trenkle ranking).
+// Keys with a script suffix ("sr-Cyrl") report the base language code.
+var langSamples = map[string]string{
+	"en": `Hello, I would like to know more about your services and the prices for the next month. Could you please
+send me the details of the offer and tell me when we can meet to discuss the project? We have been working with
+other companies in the past, but they did not understand what we needed. Thank you for your time and I am looking
+forward to hearing from you soon. The order arrived yesterday but one of the items was missing from the box, and
+there is no information about the delivery on the website. What should I do now? Please let me know if there is
+anything else that you need from me.`,
+	"de": `Hallo, ich würde gerne mehr über Ihre Dienstleistungen und die Preise für den nächsten Monat erfahren.
+Könnten Sie mir bitte die Einzelheiten des Angebots schicken und mir sagen, wann wir uns treffen können, um das
+Projekt zu besprechen? Wir haben in der Vergangenheit mit anderen Firmen zusammengearbeitet, aber sie haben nicht
+verstanden, was wir brauchen. Vielen Dank für Ihre Zeit und ich freue mich darauf, bald von Ihnen zu hören. Die
+Bestellung ist gestern angekommen, aber einer der Artikel fehlte in der Schachtel, und auf der Webseite gibt es keine
+Informationen über die Lieferung. Was soll ich jetzt tun? Bitte lassen Sie mich wissen, ob Sie noch etwas brauchen.`,
+	"fr": `Bonjour, je voudrais en savoir plus sur vos services et les prix pour le mois prochain. Pourriez-vous
+m'envoyer les détails de l'offre et me dire quand nous pouvons nous rencontrer pour discuter du projet? Nous avons
+travaillé avec d'autres entreprises dans le passé, mais elles n'ont pas compris ce dont nous avions besoin. Merci
+pour votre temps et j'attends votre réponse avec impatience. La commande est arrivée hier mais un des articles
+manquait dans la boîte, et il n'y a aucune information sur la livraison sur le site. Que dois-je faire maintenant?
+Merci de me dire si vous avez besoin d'autre chose de ma part.`,
+	"es": `Hola, me gustaría saber más sobre sus servicios y los precios para el próximo mes. ¿Podrían enviarme los
+detalles de la oferta y decirme cuándo podemos reunirnos para hablar del proyecto? Hemos trabajado con otras
+empresas en el pasado, pero no entendieron lo que necesitábamos. Gracias por su tiempo y espero tener noticias suyas
+pronto. El pedido llegó ayer pero faltaba uno de los artículos en la caja, y no hay información sobre la entrega en
+la página web. ¿Qué debo hacer ahora? Por favor, díganme si necesitan algo más de mi parte.`,
+	"it": `Buongiorno, vorrei sapere di più sui vostri servizi e sui prezzi per il prossimo mese. Potreste inviarmi i
+dettagli dell'offerta e dirmi quando possiamo incontrarci per discutere del progetto? Abbiamo lavorato con altre
+aziende in passato, ma non hanno capito di cosa avevamo bisogno. Grazie per il vostro tempo e spero di sentirvi
+presto. L'ordine è arrivato ieri ma uno degli articoli mancava nella scatola, e non ci sono informazioni sulla
+consegna sul sito. Cosa devo fare adesso? Fatemi sapere se avete bisogno di qualcos'altro da parte mia.`,
+	"pt": `Olá, gostaria de saber mais sobre os vossos serviços e os preços para o próximo mês. Poderiam enviar-me os
+detalhes da oferta e dizer-me quando podemos encontrar-nos para discutir o projeto? Já trabalhamos com outras
+empresas no passado, mas elas não entenderam o que precisávamos. Obrigado pelo vosso tempo e espero ter notícias em
+breve. A encomenda chegou ontem mas faltava um dos artigos na caixa, e não há informação sobre a entrega no site. O
+que devo fazer agora? Por favor, digam-me se precisam de mais alguma coisa da minha parte.`,
+	"nl": `Hallo, ik zou graag meer willen weten over uw diensten en de prijzen voor de volgende maand. Kunt u mij de
+details van de aanbieding sturen en mij laten weten wanneer we kunnen afspreken om het project te bespreken? We
+hebben in het verleden met andere bedrijven gewerkt, maar zij begrepen niet wat wij nodig hadden. Bedankt voor uw
+tijd en ik hoop snel van u te horen. De bestelling is gisteren aangekomen maar een van de artikelen ontbrak in de
+doos, en er staat geen informatie over de levering op de website. Wat moet ik nu doen? Laat het me weten als u nog
+iets van mij nodig heeft.`,
+	"pl": `Dzień dobry, chciałbym dowiedzieć się więcej o państwa usługach i cenach na przyszły miesiąc. Czy mogliby
+państwo przesłać mi szczegóły oferty i powiedzieć, kiedy możemy się spotkać, aby omówić projekt? W przeszłości
+współpracowaliśmy z innymi firmami, ale nie rozumiały one, czego potrzebujemy. Dziękuję za poświęcony czas i czekam
+na odpowiedź. Zamówienie dotarło wczoraj, ale w pudełku brakowało jednego z artykułów, a na stronie nie ma żadnych
+informacji o dostawie. Co mam teraz zrobić? Proszę dać mi znać, jeśli potrzebują państwo czegoś jeszcze.`,
+	// Serbian is written in both alphabets; each gets its own profile.
+	"sr": `Zdravo, želeo bih da saznam više o vašim uslugama i cenama za sledeći mesec. Da li biste mogli da mi
+pošaljete detalje ponude i kažete kada možemo da se nađemo da razgovaramo o projektu? Radili smo sa drugim firmama
+ranije, ali nisu razumeli šta nam je potrebno. Hvala vam na vremenu i radujem se što ću uskoro čuti od vas.
+Porudžbina je stigla juče, ali jedan od artikala je nedostajao u kutiji, a na sajtu nema informacija o isporuci.
+Šta treba sada da uradim? Molim vas da mi javite ako vam je još nešto potrebno od mene. Želeo bih da znam da li
+postoji popust za veće količine i koliko traje dostava.`,
+	"sr-Cyrl": `Здраво, желео бих да сазнам више о вашим услугама и ценама за следећи месец. Да ли бисте могли да ми
+пошаљете детаље понуде и кажете када можемо да се нађемо да разговарамо о пројекту? Радили смо са другим фирмама
+раније, али нису разумели шта нам је потребно. Хвала вам на времену и радујем се што ћу ускоро чути од вас.
+Поруџбина је стигла јуче, али један од артикала је недостајао у кутији, а на сајту нема информација о испоруци. Шта
+треба сада да урадим? Молим вас да ми јавите ако вам је још нешто потребно од мене. Желео бих да знам да ли постоји
+попуст за веће количине и колико траје достава.`,
+	"ru": `Здравствуйте, я хотел бы узнать больше о ваших услугах и ценах на следующий месяц. Не могли бы вы
+прислать мне подробности предложения и сказать, когда мы можем встретиться, чтобы обсудить проект? Раньше мы
+работали с другими компаниями, но они не поняли, что нам было нужно. Спасибо за ваше время, и я с нетерпением жду
+вашего ответа. Заказ пришёл вчера, но одного из товаров не было в коробке, и на сайте нет никакой информации о
+доставке. Что мне теперь делать? Пожалуйста, дайте мне знать, если вам нужно что-нибудь ещё от меня.`,
+}
+
+const langProfileSize = 300
+
+var (
+	langOnce     sync.Once
+	langProfiles map[string]map[string]int // profile key -> n-gram -> rank
+)
+
+func loadLangProfiles() {
+	langProfiles = make(map[string]map[string]int, len(langSamples))
+	for lang, text := range langSamples {
+		ranked := rankedNgrams(text, langProfileSize)
+		prof := make(map[string]int, len(ranked))
+		for i, g := range ranked {
+			prof[g] = i
+		}
+		langProfiles[lang] = prof
+	}
+}
+
+// rankedNgrams returns the most frequent 1..3-grams of the words in text,
+// most frequent first. Words are padded with '_' so prefixes/suffixes count.
+func rankedNgrams(text string, limit int) []string {
+	freq := map[string]int{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+	for _, w := range words {
+		rs := []rune("_" + w + "_")
+		for n := 1; n <= 3; n++ {
+			for i := 0; i+n <= len(rs); i++ {
+				g := string(rs[i : i+n])
+				if g == "_" {
+					continue
+				}
+				freq[g]++
+			}
+		}
+	}
+	out := make([]string, 0, len(freq))
+	for g := range freq {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if freq[out[i]] == freq[out[j]] {
+			return out[i] < out[j]
+		}
+		return freq[out[i]] > freq[out[j]]
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// DetectLanguage guesses the language of text using the embedded n-gram
+// profiles (en, de, fr, es, it, pt, nl, pl, sr, ru). It returns the ISO 639-1
+// code and a confidence between 0 and 1, or "" when the text has too few letters.
+func DetectLanguage(text string) (string, float64) {
+	langOnce.Do(loadLangProfiles)
+	doc := rankedNgrams(text, langProfileSize)
+	if len(doc) < 20 {
+		return "", 0
+	}
+	best, second := "", -1
+	bestDist := -1
+	for key, prof := range langProfiles {
+		lang := key
+		if i := strings.IndexByte(key, '-'); i > 0 {
+			lang = key[:i]
+		}
+		dist := 0
+		for i, g := range doc {
+			if rank, ok := prof[g]; ok {
+				d := rank - i
+				if d < 0 {
+					d = -d
+				}
+				dist += d
+			} else {
+				dist += langProfileSize
+			}
+		}
+		if bestDist < 0 || dist < bestDist || (dist == bestDist && lang < best) {
+			second = bestDist
+			best, bestDist = lang, dist
+		} else if second < 0 || dist < second {
+			second = dist
+		}
+	}
+	if second <= 0 {
+		return best, 1
+	}
+	// Relative margin to the runner-up, scaled so a 10% gap is full confidence.
+	conf := float64(second-bestDist) / float64(second) * 10
+	if conf > 1 {
+		conf = 1
+	}
+	return best, conf
+}
+
+// languagePolicy returns the effective language policy for r (route first, then Config).
+func (c *Captcha) languagePolicy(r *http.Request) *LanguagePolicy {
+	if rt := c.route(r); rt != nil && rt.Languages != nil {
+		return rt.Languages
+	}
+	return c.cfg.Languages
+}
+
+// scoreLanguage detects the language of msg and penalizes unexpected ones.
+// The detected language is always returned as a "lang:<code>" reason.
+func (c *Captcha) scoreLanguage(r *http.Request, msg string) (int, []string) {
+	p := c.languagePolicy(r)
+	if p == nil {
+		return 0, nil
+	}
+	minLen := p.MinLength
+	if minLen <= 0 {
+		minLen = 40
+	}
+	if utf8.RuneCountInString(msg) < minLen {
+		return 0, nil
+	}
+	lang, conf := DetectLanguage(msg)
+	if lang == "" {
+		return 0, nil
+	}
+	reasons := []string{"lang:" + lang}
+	if len(p.Expected) == 0 || conf < 0.3 {
+		return 0, reasons
+	}
+	for _, want := range p.Expected {
+		if strings.EqualFold(want, lang) {
+			return 0, reasons
+		}
+	}
+	pen := p.Penalty
+	if pen == 0 {
+		pen = 2
+	}
+	return -pen, append(reasons, "off_language:"+lang)
+}
@@ -0,0 +1,300 @@
+package gocaptcha
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Model maps extracted request features to the probability (0..1) that the
+// request was sent by a bot. Feature names are listed in RequestFeatures.
+type Model interface {
+	Predict(features map[string]float64) float64
+}
+
+// modelFile is the on-disk JSON format understood by ParseModel.
+//
+// Logistic regression:
+//
+//	{"type": "logistic", "bias": -1.5, "weights": {"has_js_cookie": -2.1, "behavior_events": -0.04}}
+//
+// Gradient-boosted trees (raw margins are summed, then passed through a sigmoid):
+//
+//	{"type": "gbt", "base_score": 0.0, "trees": [
+//	  {"nodes": [
+//	    {"feature": "submit_delay_ms", "threshold": 1500, "left": 1, "right": 2},
+//	    {"leaf": 1.2},
+//	    {"leaf": -0.8}
+//	  ]}
+//	]}
+//
+// Tree nodes go left when the feature value is < threshold. Missing features are 0.
+type modelFile struct {
+	Type      string             `json:"type"`
+	Bias      float64            `json:"bias"`
+	Weights   map[string]float64 `json:"weights"`
+	BaseScore float64            `json:"base_score"`
+	Trees     []gbtTree          `json:"trees"`
+}
+
+// LogisticModel is a logistic regression over named features.
+type LogisticModel struct {
+	Bias    float64
+	Weights map[string]float64
+}
+
+// Predict implements Model.
+func (m *LogisticModel) Predict(features map[string]float64) float64 {
+	z := m.Bias
+	for name, w := range m.Weights {
+		z += w * features[name]
+	}
+	return sigmoid(z)
+}
+
+type gbtNode struct {
+	Feature   string   `json:"feature,omitempty"`
+	Threshold float64  `json:"threshold,omitempty"`
+	Left      int      `json:"left,omitempty"`
+	Right     int      `json:"right,omitempty"`
+	Leaf      *float64 `json:"leaf,omitempty"`
+}
+
+type gbtTree struct {
+	Nodes []gbtNode `json:"nodes"`
+}
+
+// TreeModel is an ensemble of gradient-boosted regression trees.
+type TreeModel struct {
+	BaseScore float64
+	trees     []gbtTree
+}
+
+// Predict implements Model.
+func (m *TreeModel) Predict(features map[string]float64) float64 {
+	z := m.BaseScore
+	for _, t := range m.trees {
+		i := 0
+		for steps := 0; steps < len(t.Nodes); steps++ {
+			n := t.Nodes[i]
+			if n.Leaf != nil {
+				z += *n.Leaf
+				break
+			}
+			if features[n.Feature] < n.Threshold {
+				i = n.Left
+			} else {
+				i = n.Right
+			}
+		}
+	}
+	return sigmoid(z)
+}
+
+func sigmoid(z float64) float64 {
+	return 1 / (1 + math.Exp(-z))
+}
+
+// ParseModel decodes a JSON model file (see LoadModel for the format).
+func ParseModel(data []byte) (Model, error) {
+	var mf modelFile
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(mf.Type) {
+	case "logistic", "logreg":
+		if len(mf.Weights) == 0 {
+			return nil, errors.New("logistic model has no weights")
+		}
+		return &LogisticModel{Bias: mf.Bias, Weights: mf.Weights}, nil
+	case "gbt", "trees":
+		if len(mf.Trees) == 0 {
+			return nil, errors.New("tree model has no trees")
+		}
+		for ti, t := range mf.Trees {
+			if len(t.Nodes) == 0 {
+				return nil, fmt.Errorf("tree %d has no nodes", ti)
+			}
+			for ni, n := range t.Nodes {
+				if n.Leaf != nil {
+					continue
+				}
+				if n.Left <= ni || n.Right <= ni || n.Left >= len(t.Nodes) || n.Right >= len(t.Nodes) {
+					return nil, fmt.Errorf("tree %d node %d has invalid children", ti, ni)
+				}
+			}
+		}
+		return &TreeModel{BaseScore: mf.BaseScore, trees: mf.Trees}, nil
+	default:
+		return nil, fmt.Errorf("unknown model type %q", mf.Type)
+	}
+}
+
+// LoadModel reads a JSON model file from disk. Supported types are "logistic"
+// (bias + per-feature weights) and "gbt" (gradient-boosted trees).
+func LoadModel(path string) (Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseModel(data)
+}
+
+// modelScorer holds the active model and reloads it when the file changes.
+type modelScorer struct {
+	path string
+
+	mu        sync.Mutex
+	model     Model
+	modTime   time.Time
+	lastCheck time.Time
+	err       error
+}
+
+// current returns the active model, reloading the file at most once per second
+// when its modification time has changed.
+func (s *modelScorer) current() Model {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return s.model
+	}
+	now := time.Now()
+	if now.Sub(s.lastCheck) < time.Second {
+		return s.model
+	}
+	s.lastCheck = now
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		s.err = err
+		return s.model
+	}
+	if !fi.ModTime().Equal(s.modTime) {
+		m, err := LoadModel(s.path)
+		s.err = err
+		if err == nil {
+			s.model = m
+			s.modTime = fi.ModTime()
+		}
+	}
+	return s.model
+}
+
+// SetModel replaces the active behavior model. Passing nil disables model
+// scoring. A model set this way stays active until ModelPath changes on disk.
+func (c *Captcha) SetModel(m Model) {
+	c.model.mu.Lock()
+	c.model.model = m
+	c.model.mu.Unlock()
+}
+
+// ModelError returns the last error seen while loading Config.ModelPath, if any.
+func (c *Captcha) ModelError() error {
+	c.model.mu.Lock()
+	defer c.model.mu.Unlock()
+	return c.model.err
+}
+
+// RequestFeatures extracts the numeric features fed to the behavior model.
+// Boolean signals are encoded as 0/1. Feature names:
+//
+//	behavior_events, behavior_duration_ms, behavior_distance, behavior_interval_std,
+//	behavior_keys, behavior_clicks, has_ts, submit_delay_ms, has_js_token,
+//	has_js_cookie, ua_mozilla, ua_scripted, has_referer, cross_site_referer,
+//	has_accept, has_accept_language, has_sec_fetch, rate_hits, msg_length, msg_links
+//
+// Call it after the form has been parsed (CheckRequest does this). An
+// X-GoCaptcha-Token is read without being spent, so a later CheckRequest
+// still accepts it.
+func (c *Captcha) RequestFeatures(r *http.Request) map[string]float64 {
+	if p, err := c.parseToken(strings.TrimSpace(r.Header.Get(TokenHeader))); err == nil {
+		r = withToken(r, p)
+	}
+	return c.requestFeatures(r, time.Now(), 0)
+}
+
+func (c *Captcha) requestFeatures(r *http.Request, now time.Time, rateHits int) map[string]float64 {
+	f := map[string]float64{}
+	b := func(v bool) float64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+
+	if events, err := decodeBehavior(c.captchaValue(r, "behavior_data")); err == nil && len(events) > 0 {
+		f["behavior_events"] = float64(len(events))
+		f["behavior_duration_ms"] = float64(events[len(events)-1].T - events[0].T)
+		var dist, sum, sumsq float64
+		for i, ev := range events {
+			if ev.Key {
+				f["behavior_keys"]++
+			}
+			if ev.Click {
+				f["behavior_clicks"]++
+			}
+			if i == 0 {
+				continue
+			}
+			dist += math.Hypot(float64(ev.X-events[i-1].X), float64(ev.Y-events[i-1].Y))
+			dt := float64(ev.T - events[i-1].T)
+			sum += dt
+			sumsq += dt * dt
+		}
+		f["behavior_distance"] = dist
+		if n := float64(len(events) - 1); n > 0 {
+			mean := sum / n
+			f["behavior_interval_std"] = math.Sqrt(math.Max(sumsq/n-mean*mean, 0))
+		}
+	}
+
+	if ts, err := strconv.ParseInt(c.captchaValue(r, "ts"), 10, 64); err == nil {
+		f["has_ts"] = 1
+		f["submit_delay_ms"] = float64(now.UnixMilli() - ts)
+	}
+	f["has_js_token"] = b(c.validJSToken(c.captchaValue(r, "ts"), c.captchaValue(r, "js_token"), now))
+	if pen, _ := c.checkJSCookie(r); pen == 0 {
+		f["has_js_cookie"] = 1
+	}
+
+	ua := r.Header.Get("User-Agent")
+	f["ua_mozilla"] = b(strings.Contains(strings.ToLower(ua), "mozilla"))
+	f["ua_scripted"] = b(isScriptedUA(ua))
+	ref := r.Header.Get("Referer")
+	f["has_referer"] = b(ref != "")
+	f["cross_site_referer"] = b(ref != "" && r.Host != "" && !strings.Contains(ref, r.Host))
+	f["has_accept"] = b(r.Header.Get("Accept") != "")
+	f["has_accept_language"] = b(r.Header.Get("Accept-Language") != "")
+	f["has_sec_fetch"] = b(r.Header.Get("Sec-Fetch-Site") != "" || r.Header.Get("Sec-Fetch-Mode") != "")
+	f["rate_hits"] = float64(rateHits)
+
+	msg := strings.TrimSpace(c.formFields(r)[RoleMessage])
+	f["msg_length"] = float64(len([]rune(msg)))
+	f["msg_links"] = float64(len(urlRe.FindAllString(msg, -1)))
+	return f
+}
+
+// scoreModel runs the active model and converts its probability into a score
+// delta in [-ModelWeight, +ModelWeight]. A probability of 0.5 is neutral.
+func (c *Captcha) scoreModel(r *http.Request, now time.Time, rateHits int) (int, string) {
+	m := c.model.current()
+	if m == nil {
+		return 0, ""
+	}
+	p := m.Predict(c.requestFeatures(r, now, rateHits))
+	if math.IsNaN(p) {
+		return 0, ""
+	}
+	delta := int(math.Round((0.5 - p) * 2 * float64(c.cfg.ModelWeight)))
+	if p < 0.5 {
+		return delta, ""
+	}
+	return delta, "model:" + strconv.FormatFloat(p, 'f', 2, 64)
+}
@@ -0,0 +1,243 @@
+package gocaptcha
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps common non-Latin lookalikes to the Latin letter they imitate
+// (a subset of the Unicode confusables data covering Cyrillic, Greek and a few
+// symbols seen in spam such as "vіagra" with a Cyrillic "і").
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't',
+	'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ї': 'i', 'ј': 'j', 'һ': 'h', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y',
+	'ӏ': 'l', 'п': 'n', 'г': 'r',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u',
+	'χ': 'x', 'γ': 'y', 'ω': 'w', 'ϲ': 'c', 'ϳ': 'j',
+	// Latin and symbol lookalikes
+	'ı': 'i', 'ȷ': 'j', 'ɡ': 'g', 'ℓ': 'l', 'ø': 'o', 'đ': 'd', 'ł': 'l',
+}
+
+// isInvisible reports whether r is a zero-width or formatting character that
+// renders as nothing (ZWSP, ZWNJ, ZWJ, word joiner, BOM, soft hyphen, bidi controls...).
+func isInvisible(r rune) bool {
+	return unicode.Is(unicode.Cf, r) || r == '\u034f' || r == '\u115f' || r == '\u1160' || r == '\u3164'
+}
+
+// isEmojiRune reports whether r is a pictographic symbol (ZWJ between two of
+// these forms a legitimate emoji sequence).
+func isEmojiRune(r rune) bool {
+	return unicode.Is(unicode.So, r) || (r >= 0x1F300 && r <= 0x1FAFF)
+}
+
+// NormalizeText applies NFKC normalization (full-width and compatibility forms
+// become plain letters) and strips invisible characters. All content checks see
+// text in this form.
+func NormalizeText(s string) string {
+	s = norm.NFKC.String(s)
+	if strings.IndexFunc(s, isInvisible) < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if !isInvisible(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Skeleton folds s for matching: NormalizeText, lowercase, combining marks
+// removed and confusable lookalikes mapped to Latin, so "Ｖіаgrа" and "viagra"
+// share the skeleton "viagra". Words mixing Latin with lookalikes are folded,
+// and so are words made only of lookalikes ("ѕех" in Cyrillic) unless their
+// script is really used in s, i.e. another word has letters of it that
+// imitate nothing. Real Cyrillic or Greek text is therefore not mangled.
+func Skeleton(s string) string {
+	s = norm.NFD.String(strings.ToLower(NormalizeText(s)))
+	words := splitWords(s)
+
+	real := map[string]bool{}
+	for _, w := range words {
+		if !hasLatinLetter(w) {
+			for _, r := range w {
+				if _, ok := confusables[r]; !ok && r >= 0x80 && unicode.IsLetter(r) {
+					real[scriptKey(r)] = true
+				}
+			}
+		}
+	}
+	fold := func(word []rune) bool {
+		if hasLatinLetter(word) {
+			return true
+		}
+		letters := 0
+		for _, r := range word {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			if _, ok := confusables[r]; !ok || real[scriptKey(r)] {
+				return false
+			}
+			letters++
+		}
+		return letters > 0
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	word := []rune{}
+	flush := func() {
+		if fold(word) {
+			for _, r := range word {
+				if m, ok := confusables[r]; ok {
+					r = m
+				}
+				b.WriteRune(r)
+			}
+		} else {
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+	for _, r := range s {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		b.WriteRune(r)
+	}
+	flush()
+	return norm.NFC.String(b.String())
+}
+
+// splitWords returns the runs of letters and digits in s, skipping
+// combining marks like Skeleton does.
+func splitWords(s string) [][]rune {
+	var words [][]rune
+	var word []rune
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			if len(word) > 0 {
+				words = append(words, word)
+				word = nil
+			}
+		}
+	}
+	if len(word) > 0 {
+		words = append(words, word)
+	}
+	return words
+}
+
+// scriptKey groups a letter by the scripts the confusables table covers.
+func scriptKey(r rune) string {
+	switch {
+	case unicode.Is(unicode.Cyrillic, r):
+		return "Cyrillic"
+	case unicode.Is(unicode.Greek, r):
+		return "Greek"
+	case unicode.Is(unicode.Latin, r):
+		return "Latin"
+	}
+	return "other"
+}
+
+// isConfusable reports whether r is a non-Latin lookalike of a Latin letter.
+func isConfusable(r rune) bool {
+	_, ok := confusables[unicode.ToLower(r)]
+	return ok && !unicode.Is(unicode.Latin, r)
+}
+
+func hasLatinLetter(word []rune) bool {
+	for _, r := range word {
+		if r < 0x80 && unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// obfuscationStats counts invisible characters and words that hide
+// confusable lookalikes from other scripts inside a Latin run ("pаypal" with
+// a Cyrillic а). A lookalike only counts with Latin letters on both sides,
+// so words that merely start or end in another script ("Ωmega") do not.
+func obfuscationStats(s string) (invisible, mixedWords int) {
+	var prev rune
+	word := []rune{}
+	check := func() {
+		latin, pending := false, false
+		for _, r := range word {
+			switch {
+			case unicode.Is(unicode.Latin, r):
+				if pending {
+					mixedWords++
+					word = word[:0]
+					return
+				}
+				latin = true
+			case latin && isConfusable(r):
+				pending = true
+			}
+		}
+		word = word[:0]
+	}
+	runes := []rune(norm.NFKC.String(s))
+	for i, r := range runes {
+		if isInvisible(r) {
+			// ZWJ inside emoji sequences is legitimate
+			if r == '\u200d' && isEmojiRune(prev) && i+1 < len(runes) && isEmojiRune(runes[i+1]) {
+				continue
+			}
+			invisible++
+			continue // invisible characters do not split words
+		}
+		if unicode.IsLetter(r) || unicode.IsMark(r) {
+			word = append(word, r)
+		} else {
+			check()
+		}
+		prev = r
+	}
+	check()
+	return invisible, mixedWords
+}
+
+// scoreObfuscation turns heavy use of invisible or confusable characters in
+// any submitted field into a penalty.
+func (c *Captcha) scoreObfuscation(values []string) (int, []string) {
+	invisible, mixed := 0, 0
+	for _, v := range values {
+		i, m := obfuscationStats(v)
+		invisible += i
+		mixed += m
+	}
+	delta := 0
+	var reasons []string
+	if invisible >= 3 {
+		delta -= 1
+		if invisible >= 10 {
+			delta -= 1
+		}
+		reasons = append(reasons, "invisible_chars:"+strconv.Itoa(invisible))
+	}
+	if mixed > 0 {
+		delta -= 2
+		reasons = append(reasons, "confusable_chars:"+strconv.Itoa(mixed))
+	}
+	return delta, reasons
+}
@@ -0,0 +1,54 @@
+package gocaptcha
+
+import "testing"
+
+func TestObfuscationStats(t *testing.T) {
+	tests := []struct {
+		in        string
+		invisible int
+		mixed     int
+	}{
+		{"Zoë Müller-Ωmega", 0, 0},
+		{"Ωmega", 0, 0},
+		{"ΑΒΓ Δέλτα", 0, 0},
+		{"Cafеω", 0, 0},        // trailing Cyrillic е and Greek ω
+		{"pаypal login", 0, 1}, // Cyrillic а
+		{"frее mоney", 0, 1},   // Cyrillic ее ends "frее", о is inside "mоney"
+		{"Ωpаypal", 0, 1},
+		{"free\u200bmoney", 1, 0},
+		{"👩\u200d💻", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			inv, mixed := obfuscationStats(tt.in)
+			if inv != tt.invisible || mixed != tt.mixed {
+				t.Errorf("obfuscationStats(%q) = %d, %d, want %d, %d", tt.in, inv, mixed, tt.invisible, tt.mixed)
+			}
+		})
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Ｖіаgrа", "viagra"},
+		{"ѕех", "sex"},                 // Cyrillic lookalikes only
+		{"ΑΒΕ", "abe"},                 // Greek lookalikes only
+		{"сеть", "сеть"},               // real Russian word: т, ь imitate nothing
+		{"сахар и мёд", "сахар и мед"}, // Cyrillic is really used, so сахар stays
+		{"привет ѕех", "привет ѕех"},   // same: Cyrillic text is left alone
+		{"Zoë Müller-Ωmega", "zoe muller-wmega"},
+		{"ɡood", "good"},
+	}
+	for _, tt := range tests {
+		if got := Skeleton(tt.in); got != tt.want {
+			t.Errorf("Skeleton(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatchKeywordsCyrillicLookalikes(t *testing.T) {
+	c := New(Config{})
+	if got := c.MatchKeywords("ѕех"); len(got) != 1 || got[0] != "sex" {
+		t.Errorf("MatchKeywords(ѕех) = %v, want [sex]", got)
+	}
+}
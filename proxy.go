@@ -0,0 +1,199 @@
+package gocaptcha
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// cloudflareRanges are Cloudflare's published edge ranges
+// (https://www.cloudflare.com/ips/).
+var cloudflareRanges = []string{
+	"173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22",
+	"141.101.64.0/18", "108.162.192.0/18", "190.93.240.0/20", "188.114.96.0/20",
+	"197.234.240.0/22", "198.41.128.0/17", "162.158.0.0/15", "104.16.0.0/13",
+	"104.24.0.0/14", "172.64.0.0/13", "131.0.72.0/22",
+	"2400:cb00::/32", "2606:4700::/32", "2803:f800::/32", "2405:b500::/32",
+	"2405:8100::/32", "2a06:98c0::/29", "2c0f:f248::/32",
+}
+
+var cloudflarePrefixes = mustPrefixes(cloudflareRanges...)
+
+func mustPrefixes(cidrs ...string) []netip.Prefix {
+	out := make([]netip.Prefix, len(cidrs))
+	for i, s := range cidrs {
+		out[i] = netip.MustParsePrefix(s)
+	}
+	return out
+}
+
+// CloudflareProxies returns Cloudflare's edge ranges, for Config.TrustedProxies
+// when your origin is behind Cloudflare. The list changes rarely; check
+// https://www.cloudflare.com/ips/ when upgrading.
+func CloudflareProxies() []netip.Prefix {
+	return append([]netip.Prefix(nil), cloudflarePrefixes...)
+}
+
+func isCloudflare(a netip.Addr) bool {
+	for _, p := range cloudflarePrefixes {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoopbackProxies returns 127.0.0.0/8 and ::1, for a reverse proxy (Caddy,
+// Nginx) on the same host.
+func LoopbackProxies() []netip.Prefix {
+	return mustPrefixes("127.0.0.0/8", "::1/128")
+}
+
+// PrivateProxies returns the private and unique local ranges (10/8,
+// 172.16/12, 192.168/16, fc00::/7), for proxies on an internal network such
+// as a Docker or Kubernetes ingress.
+func PrivateProxies() []netip.Prefix {
+	return mustPrefixes("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+}
+
+// ipNetwork returns the network ip is counted under by the rate limiter, the
+// reputation store and the ip_prefix log column: "203.0.113.7/32" or
+// "2001:db8:1:2::/64" with the default Config.IPv4Prefix and IPv6Prefix.
+// Unparseable input is returned unchanged.
+func (c *Captcha) ipNetwork(ip string) string {
+	a, ok := parseHop(ip)
+	if !ok {
+		return ip
+	}
+	bits := c.cfg.IPv6Prefix
+	if a.Is4() {
+		bits = c.cfg.IPv4Prefix
+	}
+	p, err := a.WithZone("").Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return p.String()
+}
+
+// trusted reports whether a is in Config.TrustedProxies.
+func (c *Captcha) trusted(a netip.Addr) bool {
+	for _, p := range c.cfg.TrustedProxies {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustsPeer reports whether proxy headers of r may be believed: the peer is
+// in TrustedProxies or, without that list, TrustProxyHeaders is set.
+func (c *Captcha) trustsPeer(r *http.Request) bool {
+	if len(c.cfg.TrustedProxies) == 0 {
+		return c.cfg.TrustProxyHeaders
+	}
+	peer, ok := parseHop(remoteHost(r))
+	return ok && c.trusted(peer)
+}
+
+// forwardedHTTPS reports whether the request reached the first proxy over
+// TLS, according to a trusted X-Forwarded-Proto.
+func (c *Captcha) forwardedHTTPS(r *http.Request) bool {
+	return r.TLS != nil || c.trustsPeer(r) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseHop parses one address from a proxy header: optional quotes, IPv6
+// brackets and port. IPv4-mapped IPv6 addresses are unmapped.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if h, _, err := net.SplitHostPort(s); err == nil {
+		s = h
+	}
+	a, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return a.Unmap(), true
+}
+
+// forwardedHops returns the for= value of each Forwarded element in order.
+// Elements without one yield "" (an invalid hop).
+func forwardedHops(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(elem, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "for") {
+					hop = kv[1]
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// trustedClientIP resolves the client address with Config.TrustedProxies.
+// Proxy headers are only read when the peer is trusted, and only the one
+// named by Config.ForwardedHeader: other headers pass through proxies
+// untouched and are client-controlled. X-Forwarded-For and Forwarded are
+// walked from the right, skipping trusted hops; the first untrusted hop is
+// the client. An unparseable hop ends the walk at the last good one, and a
+// chain of only trusted hops yields its left-most entry. CF-Connecting-IP is
+// used only when the peer is a trusted Cloudflare edge address.
+func (c *Captcha) trustedClientIP(r *http.Request) string {
+	host := remoteHost(r)
+	peer, ok := parseHop(host)
+	if !ok {
+		return host
+	}
+	if !c.trusted(peer) {
+		return peer.String()
+	}
+
+	if isCloudflare(peer) {
+		if a, ok := parseHop(r.Header.Get("CF-Connecting-IP")); ok {
+			return a.String()
+		}
+	}
+
+	var hops []string
+	switch http.CanonicalHeaderKey(c.cfg.ForwardedHeader) {
+	case "Forwarded":
+		hops = forwardedHops(r.Header.Values("Forwarded"))
+	case "X-Real-Ip":
+		if a, ok := parseHop(r.Header.Get("X-Real-IP")); ok {
+			return a.String()
+		}
+	default:
+		if v := r.Header.Values("X-Forwarded-For"); len(v) > 0 {
+			hops = strings.Split(strings.Join(v, ","), ",")
+		}
+	}
+	if len(hops) > 0 {
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			a, ok := parseHop(hops[i])
+			if !ok {
+				break
+			}
+			client = a
+			if !c.trusted(a) {
+				break
+			}
+		}
+		return client.String()
+	}
+	return peer.String()
+}
@@ -0,0 +1,125 @@
+package gocaptcha
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedClientIP(t *testing.T) {
+	local := LoopbackProxies()
+	behindCF := append(LoopbackProxies(), CloudflareProxies()...)
+
+	tests := []struct {
+		name    string
+		header  string // Config.ForwardedHeader
+		trusted bool   // Cloudflare ranges trusted too
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "untrusted peer ignores headers",
+			remote:  "203.0.113.9:1234",
+			headers: map[string]string{"X-Forwarded-For": "1.1.1.1", "CF-Connecting-IP": "9.9.9.9"},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "spoofed left-most entry is ignored",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "client-sent Forwarded is ignored by default",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "9.9.9.9", "Forwarded": "for=1.2.3.4"},
+			want:    "9.9.9.9",
+		},
+		{
+			name:    "client-sent X-Real-IP is ignored by default",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Real-IP": "1.2.3.4"},
+			want:    "127.0.0.1",
+		},
+		{
+			name:    "CF-Connecting-IP from a non-Cloudflare peer is ignored",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"CF-Connecting-IP": "1.2.3.4", "X-Forwarded-For": "198.51.100.7"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "CF-Connecting-IP from a trusted Cloudflare peer",
+			trusted: true,
+			remote:  "104.16.0.1:443",
+			headers: map[string]string{"CF-Connecting-IP": "198.51.100.8", "X-Forwarded-For": "6.6.6.6"},
+			want:    "198.51.100.8",
+		},
+		{
+			name:    "CF-Connecting-IP needs Cloudflare trusted",
+			remote:  "104.16.0.1:443",
+			headers: map[string]string{"CF-Connecting-IP": "198.51.100.8"},
+			want:    "104.16.0.1",
+		},
+		{
+			name:    "Cloudflare hop behind a local proxy is skipped",
+			trusted: true,
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 104.16.0.1"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "Forwarded when configured",
+			header:  "Forwarded",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"Forwarded": `for=6.6.6.6, for="[2001:db8::1]:443";proto=https`, "X-Forwarded-For": "1.2.3.4"},
+			want:    "2001:db8::1",
+		},
+		{
+			name:    "X-Real-IP when configured",
+			header:  "X-Real-IP",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Real-IP": "198.51.100.8", "X-Forwarded-For": "1.2.3.4"},
+			want:    "198.51.100.8",
+		},
+		{
+			name:    "unparseable hop stops the walk",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7, garbage, 127.0.0.2"},
+			want:    "127.0.0.2",
+		},
+		{
+			name:    "only trusted hops yield the left-most",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "127.0.0.5, 127.0.0.6"},
+			want:    "127.0.0.5",
+		},
+		{
+			name:    "IPv4-mapped peer is unmapped",
+			remote:  "[::ffff:127.0.0.1]:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:   "no header yields the peer",
+			remote: "127.0.0.1:1234",
+			want:   "127.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxies := local
+			if tt.trusted {
+				proxies = behindCF
+			}
+			c := New(Config{TrustedProxies: proxies, ForwardedHeader: tt.header})
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := c.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package gocaptcha
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fieldSet holds the names the script uses for one deployment epoch: the
+// hidden inputs, the cookie, and the key of the js_token derivation. The
+// zero-key set is the classic static one (js_token "set_by_js").
+type fieldSet struct {
+	TS, JSToken, Behavior string
+	Cookie                string
+	key                   string
+}
+
+var staticFields = fieldSet{TS: "ts", JSToken: "js_token", Behavior: "behavior_data", Cookie: "js_captcha"}
+
+// name maps a canonical field name ("ts", "js_token", "behavior_data") to
+// the name used in this set.
+func (fs fieldSet) name(canonical string) string {
+	switch canonical {
+	case "ts":
+		return fs.TS
+	case "js_token":
+		return fs.JSToken
+	case "behavior_data":
+		return fs.Behavior
+	}
+	return canonical
+}
+
+// epoch returns the rotation epoch number for t.
+func (c *Captcha) epoch(t time.Time) int64 {
+	return t.Unix() / int64(c.cfg.RotationEpoch/time.Second)
+}
+
+// epochFields derives the field set of epoch e from the secret.
+func (c *Captcha) epochFields(e int64) fieldSet {
+	derive := func(label string, n int) string {
+		m := hmac.New(sha256.New, c.secret)
+		m.Write([]byte("epoch|" + strconv.FormatInt(e, 10) + "|" + label))
+		sum := m.Sum(nil)
+		const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = letters[int(sum[i])%len(letters)]
+		}
+		// Names must not start with a digit (the script looks them up by id)
+		if b[0] >= '0' && b[0] <= '9' {
+			b[0] = 'a' + b[0] - '0'
+		}
+		return string(b)
+	}
+	return fieldSet{
+		TS:       derive("ts", 8),
+		JSToken:  derive("js_token", 8),
+		Behavior: derive("behavior", 8),
+		Cookie:   "_" + derive("cookie", 7),
+		key:      derive("key", 12),
+	}
+}
+
+// acceptedFields returns the field sets CheckRequest accepts now: the static
+// set without rotation, else the current and the previous epoch.
+func (c *Captcha) acceptedFields(now time.Time) []fieldSet {
+	if !c.cfg.RotateFields {
+		return []fieldSet{staticFields}
+	}
+	e := c.epoch(now)
+	return []fieldSet{c.epochFields(e), c.epochFields(e - 1)}
+}
+
+// currentFields is the field set pages are rendered with.
+func (c *Captcha) currentFields() fieldSet {
+	return c.acceptedFields(time.Now())[0]
+}
+
+// requestFields returns the accepted field set the request was built with,
+// recognized by its timestamp field or cookie, or the current one.
+func (c *Captcha) requestFields(r *http.Request) fieldSet {
+	sets := c.acceptedFields(time.Now())
+	if len(sets) == 1 {
+		return sets[0]
+	}
+	for _, fs := range sets {
+		if r.Form.Get(fs.TS) != "" {
+			return fs
+		}
+	}
+	for _, fs := range sets {
+		if hasCookie(r, fs.Cookie) {
+			return fs
+		}
+	}
+	return sets[0]
+}
+
+func hasCookie(r *http.Request, name string) bool {
+	_, err := r.Cookie(name)
+	return err == nil
+}
+
+// deriveJSToken is the token the script computes from the timestamp for a
+// rotated field set: FNV-1a (32 bit) of key ":" ts, in base 36.
+func deriveJSToken(key, ts string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key + ":" + ts))
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
+
+// validJSToken reports whether tok is the js_token the script writes for ts
+// under any accepted field set.
+func (c *Captcha) validJSToken(ts, tok string, now time.Time) bool {
+	for _, fs := range c.acceptedFields(now) {
+		if fs.key == "" {
+			if tok == "set_by_js" {
+				return true
+			}
+			continue
+		}
+		if tok != "" && tok == deriveJSToken(fs.key, ts) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsCookie returns the script's cookie under the request's field set.
+func (c *Captcha) jsCookie(r *http.Request) (*http.Cookie, error) {
+	return r.Cookie(c.requestFields(r).Cookie)
+}
+
+// rotatedScripts caches the generated script per epoch.
+type rotatedScripts struct {
+	mu     sync.Mutex
+	epoch  int64
+	script []byte
+	etag   string
+}
+
+// rotatedScript returns the script for epoch e: a prelude that sets the
+// epoch's names and token derivation (strings XOR-encoded, identifiers
+// randomized) followed by the embedded script.
+func (c *Captcha) rotatedScript(e int64) ([]byte, string) {
+	c.rotated.mu.Lock()
+	defer c.rotated.mu.Unlock()
+	if c.rotated.script != nil && c.rotated.epoch == e {
+		return c.rotated.script, c.rotated.etag
+	}
+	fs := c.epochFields(e)
+	mask := c.sign("mask", strconv.FormatInt(e, 10))
+	codes := func(s string, xor bool) string {
+		parts := make([]string, len(s))
+		for i := 0; i < len(s); i++ {
+			ch := s[i]
+			if xor {
+				ch ^= mask[i%len(mask)]
+			}
+			parts[i] = strconv.Itoa(int(ch))
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+	id := func(label string) string {
+		sum := sha256.Sum256([]byte(c.sign("ident", strconv.FormatInt(e, 10)+"|"+label)))
+		return "_0x" + hex.EncodeToString(sum[:3])
+	}
+	m, dec, cf, key, s, h, i := id("m"), id("dec"), id("cfg"), id("key"), id("s"), id("h"), id("i")
+	str := func(v string) string { return dec + "(" + codes(v, true) + ")" }
+
+	var b strings.Builder
+	b.WriteString("(function(){var " + m + "=" + codes(mask, false) + ";")
+	b.WriteString("function " + dec + "(a){return a.map(function(c,i){return String.fromCharCode(c^" + m + "[i%" + m + ".length])}).join('')}")
+	b.WriteString("var " + cf + "=window.GoCaptchaConfig=window.GoCaptchaConfig||{};")
+	b.WriteString(cf + ".fields={ts:" + str(fs.TS) + ",js_token:" + str(fs.JSToken) + ",behavior_data:" + str(fs.Behavior) + "};")
+	b.WriteString(cf + ".cookie=" + str(fs.Cookie) + ";")
+	b.WriteString("var " + key + "=" + str(fs.key) + ";")
+	b.WriteString(cf + ".derive=function(t){var " + s + "=" + key + "+':'+t," + h + "=0x811c9dc5;for(var " + i + "=0;" + i + "<" + s + ".length;" + i + "++){" +
+		h + "^=" + s + ".charCodeAt(" + i + ");" + h + "=Math.imul(" + h + ",16777619)>>>0}return " + h + ".toString(36)};")
+	b.WriteString("})();\n")
+
+	script := append([]byte(b.String()), embeddedScript.data...)
+	sum := sha256.Sum256(script)
+	c.rotated.epoch, c.rotated.script, c.rotated.etag = e, script, `"`+hex.EncodeToString(sum[:8])+`"`
+	return script, c.rotated.etag
+}
+
+// serveRotatedScript writes the current epoch's script. It may be cached
+// until the epoch ends.
+func (c *Captcha) serveRotatedScript(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	e := c.epoch(now)
+	script, etag := c.rotatedScript(e)
+	epochLen := int64(c.cfg.RotationEpoch / time.Second)
+	left := (e+1)*epochLen - now.Unix()
+	h := w.Header()
+	h.Set("Content-Type", "text/javascript; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", "public, max-age="+strconv.FormatInt(left, 10))
+	h.Set("ETag", etag)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(script))
+}
@@ -0,0 +1,25 @@
+package gocaptcha
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRotateFieldsIgnoresStaticHeaders(t *testing.T) {
+	ts := strconv.FormatInt(time.Now().Add(-5*time.Second).UnixMilli(), 10)
+	for _, rotate := range []bool{false, true} {
+		c := New(Config{RotateFields: rotate})
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-GoCaptcha-TS", ts)
+		r.Header.Set("X-GoCaptcha-JS-Token", "set_by_js")
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		got := c.captchaValue(r, "ts")
+		if want := map[bool]string{false: ts, true: ""}[rotate]; got != want {
+			t.Errorf("RotateFields=%v: captchaValue(ts) = %q, want %q", rotate, got, want)
+		}
+	}
+}
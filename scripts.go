@@ -0,0 +1,161 @@
+package gocaptcha
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ScriptPolicy restricts which Unicode scripts (writing systems) may appear in
+// submitted form fields.
+type ScriptPolicy struct {
+	// Allowed script names as used by the unicode package, e.g. "Latin",
+	// "Cyrillic", "Greek", "Han", matched case-insensitively. New panics on
+	// an unknown name. Common and Inherited characters (digits,
+	// punctuation, combining marks) are always allowed. Empty disables the check.
+	Scripts []string
+	// Field names or content roles (see FieldRole) to check, case-insensitive.
+	// Empty checks every field except the honeypot.
+	Fields []string
+	// Score penalty when unexpected scripts are found. 0 blocks the request outright.
+	Penalty int
+}
+
+// Route overrides settings for requests whose URL.Path starts with PathPrefix.
+// When several routes match, the longest prefix wins.
+type Route struct {
+	PathPrefix string
+
+	// Scripts replaces Config.Scripts for this route; nil inherits it.
+	// Use &ScriptPolicy{} to disable the check on this route.
+	Scripts *ScriptPolicy
+
+	// Languages replaces Config.Languages for this route; nil inherits it.
+	Languages *LanguagePolicy
+
+	// FieldRoles are checked before Config.FieldRoles for this route.
+	FieldRoles []FieldRole
+}
+
+// route returns the most specific Route for r, or nil.
+func (c *Captcha) route(r *http.Request) *Route {
+	var best *Route
+	for i := range c.cfg.Routes {
+		rt := &c.cfg.Routes[i]
+		if rt.PathPrefix == "" || !strings.HasPrefix(r.URL.Path, rt.PathPrefix) {
+			continue
+		}
+		if best == nil || len(rt.PathPrefix) > len(best.PathPrefix) {
+			best = rt
+		}
+	}
+	return best
+}
+
+// scriptPolicy returns the effective script policy for r. The legacy
+// latin_only database flag maps to a Latin-only hard block when neither the
+// route nor Config sets a policy. The bool reports whether it came from latin_only.
+func (c *Captcha) scriptPolicy(r *http.Request) (*ScriptPolicy, bool) {
+	if rt := c.route(r); rt != nil && rt.Scripts != nil {
+		return rt.Scripts, false
+	}
+	if c.cfg.Scripts != nil {
+		return c.cfg.Scripts, false
+	}
+	if c.getConfigBool("latin_only", false) {
+		return &ScriptPolicy{Scripts: []string{"Latin"}}, true
+	}
+	return nil, false
+}
+
+// unexpectedScripts returns the sorted names of scripts found in the checked
+// form fields that the policy does not allow.
+func (c *Captcha) unexpectedScripts(r *http.Request, p *ScriptPolicy) []string {
+	if p == nil || len(p.Scripts) == 0 {
+		return nil
+	}
+	allowed := []*unicode.RangeTable{unicode.Common, unicode.Inherited}
+	for _, name := range p.Scripts {
+		if t, ok := lookupScript(name); ok {
+			allowed = append(allowed, t)
+		}
+	}
+	fields := map[string]bool{}
+	for _, f := range p.Fields {
+		fields[strings.ToLower(f)] = true
+	}
+
+	found := map[string]bool{}
+	for k, vals := range r.Form {
+		if c.isHoneypot(r, k) {
+			continue
+		}
+		if len(fields) > 0 && !fields[strings.ToLower(k)] && !fields[c.fieldRole(r, k)] {
+			continue
+		}
+		for _, v := range vals {
+			for _, ch := range NormalizeText(v) {
+				if !unicode.IsLetter(ch) && !unicode.IsMark(ch) {
+					continue
+				}
+				if unicode.IsOneOf(allowed, ch) {
+					continue
+				}
+				found[scriptOf(ch)] = true
+			}
+		}
+	}
+	out := make([]string, 0, len(found))
+	for name := range found {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// scriptsByLower indexes unicode.Scripts by lowercase name.
+var scriptsByLower = func() map[string]*unicode.RangeTable {
+	m := make(map[string]*unicode.RangeTable, len(unicode.Scripts))
+	for name, t := range unicode.Scripts {
+		m[strings.ToLower(name)] = t
+	}
+	return m
+}()
+
+// lookupScript returns the table of a script name, ignoring case.
+func lookupScript(name string) (*unicode.RangeTable, bool) {
+	t, ok := scriptsByLower[strings.ToLower(strings.TrimSpace(name))]
+	return t, ok
+}
+
+// checkScriptNames panics on script names in Config.Scripts or a route's
+// Scripts that the unicode package does not know: silently skipping one
+// would flag every letter of that script in every submission.
+func checkScriptNames(cfg Config) {
+	policies := []*ScriptPolicy{cfg.Scripts}
+	for _, rt := range cfg.Routes {
+		policies = append(policies, rt.Scripts)
+	}
+	for _, p := range policies {
+		if p == nil {
+			continue
+		}
+		for _, name := range p.Scripts {
+			if _, ok := lookupScript(name); !ok {
+				panic("gocaptcha: unknown script name " + strconv.Quote(name) + " in ScriptPolicy")
+			}
+		}
+	}
+}
+
+// scriptOf returns the Unicode script name of ch ("Unknown" if none).
+func scriptOf(ch rune) string {
+	for name, t := range unicode.Scripts {
+		if unicode.Is(t, ch) {
+			return name
+		}
+	}
+	return "Unknown"
+}
@@ -0,0 +1,37 @@
+package gocaptcha
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestScriptNamesIgnoreCase(t *testing.T) {
+	c := New(Config{Scripts: &ScriptPolicy{Scripts: []string{"latin", " CYRILLIC "}}})
+	form := url.Values{"message": {"Hello Привет Γειά"}}
+	r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.unexpectedScripts(r, c.cfg.Scripts); len(got) != 1 || got[0] != "Greek" {
+		t.Errorf("unexpectedScripts() = %v, want [Greek]", got)
+	}
+}
+
+func TestUnknownScriptNamePanics(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"config": {Scripts: &ScriptPolicy{Scripts: []string{"Latin", "Latn"}}},
+		"route":  {Routes: []Route{{PathPrefix: "/ru", Scripts: &ScriptPolicy{Scripts: []string{"Russian"}}}}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: New did not panic", name)
+				}
+			}()
+			New(cfg)
+		}()
+	}
+}
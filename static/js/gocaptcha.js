@@ -1,12 +1,39 @@
 (function () {
-    // Always set the cookie to signal JS is enabled (even if form fields are missing)
+    // Options: window.GoCaptchaConfig (see Captcha.ScriptConfigJS / config.js), overridden by
+    // data-gocaptcha-* attributes on the <script> tag.
+    const script = document.currentScript;
+    const cfg = Object.assign({
+        nonceURL: '/gocaptcha/nonce',
+        auto: true,
+    }, window.GoCaptchaConfig || {});
+    function attr(name) {
+        return script ? script.getAttribute(name) : null;
+    }
+    cfg.nonceURL = attr('data-gocaptcha-nonce-url') || attr('data-nonce-url') || cfg.nonceURL;
+    if (attr('data-gocaptcha-auto') !== null) {
+        cfg.auto = attr('data-gocaptcha-auto') !== 'false';
+    }
+    // Name of a global function to call once ready (like onReady)
+    cfg.onload = attr('data-gocaptcha-onload') || cfg.onload;
+    // Field and cookie names and the js_token routine; the server's per-epoch
+    // build (Config.RotateFields) replaces them.
+    const fields = Object.assign({ts: 'ts', js_token: 'js_token', behavior_data: 'behavior_data'}, cfg.fields || {});
+    const cookieName = cfg.cookie || 'js_captcha';
+    const derive = typeof cfg.derive === 'function' ? cfg.derive : () => 'set_by_js';
+
+    // Always set the cookie to signal JS is enabled (even if form fields are missing).
+    // With a cookie endpoint (Config.SignedJSCookie) the server sets a signed one instead.
     try {
-        document.cookie = "js_captcha=enabled; Max-Age=31536000; Path=/; SameSite=Lax";
+        if (cfg.cookieURL) {
+            fetch(cfg.cookieURL, {credentials: 'same-origin', cache: 'no-store'}).catch(() => {});
+        } else {
+            document.cookie = cookieName + "=enabled; Max-Age=31536000; Path=/; SameSite=Lax";
+        }
     } catch (e) {}
 
     // Helper to ensure a hidden input exists in a given form
     function ensureHidden(form, id) {
-        let el = form.querySelector('#' + id);
+        let el = form.querySelector('#' + id) || form.querySelector('input[name="' + id + '"]');
         if (!el) {
             el = document.createElement('input');
             el.type = 'hidden';
@@ -17,11 +44,10 @@
         return el;
     }
 
-    const forms = Array.from(document.querySelectorAll('form'));
-    if (forms.length === 0) return;
-
-    // Shared behavior events buffer
-    const events = [];
+    // Shared behavior events buffer. startedAt is the page load or the last
+    // reset() of all forms; a form reset on its own only reads events since then.
+    let events = [];
+    let startedAt = Date.now();
     document.addEventListener('mousemove', e => {
         events.push({x: e.clientX, y: e.clientY, t: Date.now()});
     });
@@ -32,19 +58,181 @@
         events.push({click: true, t: Date.now()});
     });
 
-    // Initialize and wire up each form
-    forms.forEach(form => {
-        const tsField = ensureHidden(form, 'ts');
-        const jsToken = ensureHidden(form, 'js_token');
-        const behaviorField = ensureHidden(form, 'behavior_data');
+    // eventsSince returns the recorded events at or after time since.
+    function eventsSince(since) {
+        return since ? events.filter(e => e.t >= since) : events;
+    }
+
+    function behaviorData(since) {
+        try {
+            return btoa(JSON.stringify(eventsSince(since).slice(0, 100)));
+        } catch (err) {
+            return '';
+        }
+    }
+
+    // Client-side estimate (0..1) of how human the recorded behavior looks, mirroring
+    // the server's checks. It is informational only; the server decides.
+    function localScore(since) {
+        const events = eventsSince(since);
+        const n = events.length;
+        const duration = n > 1 ? events[n - 1].t - events[0].t : 0;
+        let dist = 0;
+        for (let i = 1; i < n; i++) {
+            dist += Math.hypot((events[i].x || 0) - (events[i - 1].x || 0), (events[i].y || 0) - (events[i - 1].y || 0));
+        }
+        let score = 0;
+        if (n >= 5) score += 0.4;
+        if (duration >= 600) score += 0.3;
+        if (dist >= 40) score += 0.3;
+        return {score: score, events: n, durationMs: duration, sinceLoadMs: Date.now() - (since || startedAt)};
+    }
+
+    const readyCallbacks = [];
+    const scoreCallbacks = [];
+    let ready = false;
+
+    function emitScore(form) {
+        const s = localScore(form && form.__gocaptcha ? form.__gocaptcha.since : 0);
+        s.form = form || null;
+        scoreCallbacks.forEach(cb => {
+            try { cb(s); } catch (e) {}
+        });
+    }
+
+    function b64url(bytes) {
+        let s = '';
+        bytes.forEach(b => { s += String.fromCharCode(b); });
+        return btoa(s).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    // getToken() bundles ts, js token and behavior into one value signed with a fresh
+    // server nonce. Send it in the X-GoCaptcha-Token header:
+    //
+    //   const token = await GoCaptcha.getToken();
+    //   fetch('/api/contact', {method: 'POST', headers: {'X-GoCaptcha-Token': token}, body: ...});
+    async function getToken() {
+        const res = await fetch(cfg.nonceURL, {credentials: 'same-origin', cache: 'no-store'});
+        const nonce = (await res.json()).nonce;
+        const enc = new TextEncoder();
+        const payload = {ts: startedAt, tk: derive(String(startedAt)), b: behaviorData(), n: nonce};
+        const body = b64url(enc.encode(JSON.stringify(payload)));
+        const key = await crypto.subtle.importKey('raw', enc.encode(nonce), {name: 'HMAC', hash: 'SHA-256'}, false, ['sign']);
+        const sig = await crypto.subtle.sign('HMAC', key, enc.encode(body));
+        emitScore(null);
+        return body + '.' + b64url(new Uint8Array(sig));
+    }
+
+    // render(form, options) instruments a form. options.onScore is called with the local
+    // score on submit, in addition to callbacks registered with onScore().
+    function render(form, options) {
+        if (!form || form.nodeName !== 'FORM' || form.getAttribute('data-gocaptcha') === 'off') {
+            return;
+        }
+        const opts = options || {};
+        if (form.__gocaptcha) {
+            Object.assign(form.__gocaptcha.opts, opts);
+            return;
+        }
+        const state = {opts: opts, since: 0};
+        form.__gocaptcha = state;
 
-        tsField.value = Date.now().toString();
-        jsToken.value = 'set_by_js';
+        const tsField = ensureHidden(form, fields.ts);
+        const jsToken = ensureHidden(form, fields.js_token);
+        const behaviorField = ensureHidden(form, fields.behavior_data);
+        state.reset = () => {
+            tsField.value = Date.now().toString();
+            jsToken.value = derive(tsField.value);
+            behaviorField.value = '';
+        };
+        state.reset();
 
         form.addEventListener('submit', () => {
-            try {
-                behaviorField.value = btoa(JSON.stringify(events.slice(0, 100)));
-            } catch (err) {}
+            behaviorField.value = behaviorData(state.since);
+            emitScore(form);
+            if (typeof state.opts.onScore === 'function') {
+                try { state.opts.onScore(localScore(state.since)); } catch (e) {}
+            }
         });
+    }
+
+    // reset(form) restarts timing and behavior collection for one form, e.g.
+    // after a successful AJAX submit; other forms keep their data. reset()
+    // restarts every rendered form and getToken().
+    function reset(form) {
+        if (form) {
+            if (form.__gocaptcha) {
+                form.__gocaptcha.since = Date.now();
+                form.__gocaptcha.reset();
+            }
+            return;
+        }
+        events = [];
+        startedAt = Date.now();
+        document.querySelectorAll('form').forEach(f => {
+            if (f.__gocaptcha) {
+                f.__gocaptcha.since = 0;
+                f.__gocaptcha.reset();
+            }
+        });
+    }
+
+    function onReady(cb) {
+        if (ready) {
+            try { cb(window.GoCaptcha); } catch (e) {}
+        } else {
+            readyCallbacks.push(cb);
+        }
+    }
+
+    function onScore(cb) {
+        scoreCallbacks.push(cb);
+    }
+
+    // Auto mode instruments every form; otherwise only forms with a data-gocaptcha attribute.
+    function wanted(form) {
+        const v = form.getAttribute('data-gocaptcha');
+        if (v === 'off') return false;
+        return cfg.auto || v !== null;
+    }
+
+    function scan(root) {
+        if (!root || !root.querySelectorAll) return;
+        if (root.nodeName === 'FORM' && wanted(root)) render(root);
+        root.querySelectorAll('form').forEach(f => {
+            if (wanted(f)) render(f);
+        });
+    }
+
+    window.GoCaptcha = Object.assign(window.GoCaptcha || {}, {
+        getToken: getToken,
+        render: render,
+        reset: reset,
+        onReady: onReady,
+        onScore: onScore,
+        config: cfg,
     });
+
+    function init() {
+        if (cfg.onload && typeof window[cfg.onload] === 'function') {
+            readyCallbacks.push(window[cfg.onload]);
+        }
+        scan(document);
+        // Pick up forms added later (SPAs, modals, client-side routing)
+        if (typeof MutationObserver !== 'undefined' && document.documentElement) {
+            new MutationObserver(mutations => {
+                mutations.forEach(m => m.addedNodes.forEach(scan));
+            }).observe(document.documentElement, {childList: true, subtree: true});
+        }
+        ready = true;
+        readyCallbacks.splice(0).forEach(cb => {
+            try { cb(window.GoCaptcha); } catch (e) {}
+        });
+    }
+
+    if (document.readyState === 'loading') {
+        document.addEventListener('DOMContentLoaded', init);
+    } else {
+        init();
+    }
 })();
@@ -0,0 +1,118 @@
+package gocaptcha
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+)
+
+// formFieldsHTML returns the honeypot, the hidden captcha inputs and the
+// form token formToken (from FormToken) for one form; decoysHTML adds the
+// decoys. The honeypot is hidden by an unpredictable CSS class (not an
+// inline display:none that bots look for), defined in a <style> element or,
+// with Config.ExternalStyles, in gocaptcha.css. The hidden inputs carry no
+// id so several forms on a page stay valid; the script finds them by name.
+func (c *Captcha) formFieldsHTML(nonce, formToken string) string {
+	fs := c.currentFields()
+	s := ""
+	if !c.cfg.ExternalStyles {
+		s = `<style` + nonceAttr(nonce) + `>` + c.honeypotCSS() + `</style>`
+	}
+	return s + `<div class="` + c.honeypotClass() + `" aria-hidden="true"><input type="text" name="` + html.EscapeString(c.fieldName) + `" value="" tabindex="-1" autocomplete="off"></div>` +
+		`<input type="hidden" name="` + fs.TS + `"><input type="hidden" name="` + fs.JSToken + `"><input type="hidden" name="` + fs.Behavior + `">` +
+		`<input type="hidden" name="` + FormTokenField + `" value="` + html.EscapeString(formToken) + `">`
+}
+
+// scriptTagsHTML returns the <script> tags loading gocaptcha.js, preceded by
+// config.js when the script configuration differs from its defaults.
+func (c *Captcha) scriptTagsHTML(nonce string) string {
+	s := ""
+	if c.ScriptConfig() != defaultScriptConfig {
+		s += `<script src="` + html.EscapeString(c.endpoint("config.js")) + `"` +
+			nonceAttr(nonce) + c.integrityAttr([]byte(c.ScriptConfigJS())) + `></script>`
+	}
+	integrity := ""
+	if c.cfg.SRI && scriptIntegrity != "" && !c.cfg.RotateFields {
+		integrity = ` integrity="` + scriptIntegrity + `" crossorigin="anonymous"`
+	}
+	return s + `<script src="` + html.EscapeString(c.scriptSrc()) + `"` + nonceAttr(nonce) + integrity + `></script>`
+}
+
+// helperArgs interprets the optional template helper arguments: a string is
+// a CSP nonce, a *http.Request supplies the language and, via
+// Config.CSPNonce, the nonce.
+func (c *Captcha) helperArgs(args []interface{}) (*http.Request, string) {
+	var r *http.Request
+	nonce := ""
+	for _, a := range args {
+		switch v := a.(type) {
+		case string:
+			nonce = v
+		case *http.Request:
+			r = v
+		}
+	}
+	if nonce == "" {
+		nonce = c.cspNonce(r)
+	}
+	return r, nonce
+}
+
+// TemplateFuncs returns helpers for html/template:
+//
+//	captchaFields  honeypot, decoys, hidden inputs and a fresh form token (inside
+//	               <form>); pass the form's own field names ({{captchaFields
+//	               (captchaUses "email" "message")}}) to leave out clashing decoys
+//	captchaUses    the []string of its arguments, for captchaFields
+//	captchaScript  script tag(s) for Config.ScriptPath (before </body>)
+//	captchaBadge   BadgeHTML(), empty unless ShowBadge is set; pass the request
+//	               ({{captchaBadge .Request}}) to localize it (BadgeHTMLFor)
+//	captchaStyles  <link> to gocaptcha.css with Config.ExternalStyles (in <head>)
+//	captchaTrap    hidden trap link with Config.Traps (anywhere in <body>)
+//
+// Each helper optionally takes the CSP nonce ({{captchaScript .CSPNonce}}) or
+// the request ({{captchaScript .Request}}, nonce from Config.CSPNonce).
+//
+// Usage:
+//
+//	tmpl := template.Must(template.New("page").Funcs(cap.TemplateFuncs()).ParseFiles("page.html"))
+//
+//	<form method="post">{{captchaFields}} ... </form>
+//	{{captchaScript}}{{captchaBadge}}
+//
+// All values are escaped; the results are returned as template.HTML.
+func (c *Captcha) TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"captchaFields": func(args ...interface{}) template.HTML {
+			_, nonce := c.helperArgs(args)
+			used := map[string]bool{}
+			for _, a := range args {
+				if names, ok := a.([]string); ok {
+					for _, name := range names {
+						used[name] = true
+					}
+				}
+			}
+			tok := c.FormToken()
+			return template.HTML(c.formFieldsHTML(nonce, tok) + c.decoysHTML(used, tok))
+		},
+		"captchaUses": func(names ...string) []string {
+			return names
+		},
+		"captchaScript": func(args ...interface{}) template.HTML {
+			_, nonce := c.helperArgs(args)
+			return template.HTML(c.scriptTagsHTML(nonce))
+		},
+		"captchaBadge": func(args ...interface{}) template.HTML {
+			r, nonce := c.helperArgs(args)
+			return template.HTML(c.badgeHTML(r, nonce))
+		},
+		"captchaStyles": func(args ...interface{}) template.HTML {
+			_, nonce := c.helperArgs(args)
+			return template.HTML(c.stylesheetHTML(nonce))
+		},
+		"captchaTrap": func(args ...interface{}) template.HTML {
+			return template.HTML(c.TrapLinkHTML())
+		},
+	}
+}
@@ -0,0 +1,259 @@
+package gocaptcha
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenHeader carries the bundled captcha token produced by GoCaptcha.getToken()
+// in the embedded script, for fetch/XHR submissions without a form.
+const TokenHeader = "X-GoCaptcha-Token"
+
+var (
+	errBadSignature = errors.New("bad signature")
+	errExpired      = errors.New("expired")
+)
+
+// randomBytes returns n cryptographically random bytes.
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// sign returns the base64url HMAC-SHA256 of msg under the captcha secret,
+// domain-separated by purpose.
+func (c *Captcha) sign(purpose, msg string) string {
+	m := hmac.New(sha256.New, c.secret)
+	m.Write([]byte(purpose + "|" + msg))
+	return b64(m.Sum(nil))
+}
+
+// issue returns a random value "exp.rand.mac" signed for purpose and valid for ttl.
+func (c *Captcha) issue(purpose string, ttl time.Duration) string {
+	body := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10) + "." + b64(randomBytes(12))
+	return body + "." + c.sign(purpose, body)
+}
+
+// verify checks the signature and expiry of a value from issue.
+func (c *Captcha) verify(purpose, v string) error {
+	i := strings.LastIndexByte(v, '.')
+	if i <= 0 {
+		return errBadSignature
+	}
+	body, mac := v[:i], v[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(c.sign(purpose, body))) {
+		return errBadSignature
+	}
+	exp, err := strconv.ParseInt(strings.SplitN(body, ".", 2)[0], 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	if time.Now().Unix() > exp {
+		return errExpired
+	}
+	return nil
+}
+
+// IssueNonce returns a fresh server-signed nonce valid for Config.TokenTTL.
+// The embedded script fetches one from NonceHandler before building a token.
+func (c *Captcha) IssueNonce() string {
+	return c.issue("nonce", c.cfg.TokenTTL)
+}
+
+// verifyNonce checks the signature and expiry of a nonce from IssueNonce.
+func (c *Captcha) verifyNonce(nonce string) error {
+	return c.verify("nonce", nonce)
+}
+
+// NonceHandler serves a fresh nonce as JSON: {"nonce": "...", "expires": <unix>}.
+// Mount it where the script expects it (default "/gocaptcha/nonce", see the
+// script tag's data-gocaptcha-nonce-url attribute). Handler serves it too.
+func (c *Captcha) NonceHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := c.IssueNonce()
+		exp, _ := strconv.ParseInt(strings.SplitN(n, ".", 2)[0], 10, 64)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"nonce": n, "expires": exp})
+	})
+}
+
+// tokenPayload is the JSON bundled by GoCaptcha.getToken().
+type tokenPayload struct {
+	TS       int64  `json:"ts"`
+	JSToken  string `json:"tk"`
+	Behavior string `json:"b"`
+	Nonce    string `json:"n"`
+}
+
+// parseToken decodes and verifies a token of the form
+// base64url(payload) "." base64url(HMAC-SHA256(key=nonce, base64url(payload))).
+// The nonce itself must be a valid, unexpired nonce from IssueNonce.
+//
+// The nonce is public (NonceHandler serves it to anyone), so the HMAC only
+// detects a mangled token; it does not prove the embedded script built it.
+// What the token does guarantee is freshness and single use: the nonce is
+// server-signed, expires after TokenTTL and is spent by checkToken. The
+// values inside are client claims and are scored like their form fields.
+func (c *Captcha) parseToken(tok string) (*tokenPayload, error) {
+	i := strings.IndexByte(tok, '.')
+	if i <= 0 {
+		return nil, errBadSignature
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(tok[:i])
+	if err != nil {
+		return nil, errBadSignature
+	}
+	var p tokenPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, errBadSignature
+	}
+	if err := c.verifyNonce(p.Nonce); err != nil {
+		return nil, err
+	}
+	m := hmac.New(sha256.New, []byte(p.Nonce))
+	m.Write([]byte(tok[:i]))
+	if !hmac.Equal([]byte(tok[i+1:]), []byte(b64(m.Sum(nil)))) {
+		return nil, errBadSignature
+	}
+	return &p, nil
+}
+
+// tokenKey is the request context key of the payload accepted by checkToken.
+type tokenKey struct{}
+
+// withToken returns r carrying p for tokenValue.
+func withToken(r *http.Request, p *tokenPayload) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tokenKey{}, p))
+}
+
+// tokenValue returns a captcha field from the X-GoCaptcha-Token accepted for
+// this request. evaluate parses and spends the token once (checkToken) and
+// attaches it with withToken; expired, forged and replayed tokens supply
+// nothing.
+func (c *Captcha) tokenValue(r *http.Request, name string) string {
+	p, _ := r.Context().Value(tokenKey{}).(*tokenPayload)
+	if p == nil {
+		return ""
+	}
+	switch name {
+	case "ts":
+		return strconv.FormatInt(p.TS, 10)
+	case "js_token":
+		return p.JSToken
+	case "behavior_data":
+		return p.Behavior
+	}
+	return ""
+}
+
+// nonceCacheBucket is the width, in seconds, of the expiry buckets of nonceCache.
+const nonceCacheBucket = 60
+
+// nonceCache remembers nonces and form tokens already spent until they
+// expire. Entries are grouped by expiry minute, so a spend looks in one
+// bucket and expired entries are dropped a whole bucket at a time, at most
+// once a minute. The cache lives in process memory: each instance only knows
+// its own spends, so behind a load balancer a token replayed to another
+// replica is not detected (use sticky sessions if that matters).
+type nonceCache struct {
+	mu      sync.Mutex
+	buckets map[int64]map[string]struct{} // expiry (unix) / nonceCacheBucket -> spent values
+	swept   int64                         // bucket index of the last sweep
+}
+
+// spend marks nonce as used and reports whether it was unused. The nonce
+// must start with its expiry ("exp.rand.mac", see issue) and be verified.
+func (nc *nonceCache) spend(nonce string, now time.Time) bool {
+	exp, _ := strconv.ParseInt(strings.SplitN(nonce, ".", 2)[0], 10, 64)
+	b := exp / nonceCacheBucket
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.buckets == nil {
+		nc.buckets = make(map[int64]map[string]struct{})
+	}
+	if cur := now.Unix() / nonceCacheBucket; cur > nc.swept {
+		for k := range nc.buckets {
+			if k < cur {
+				delete(nc.buckets, k)
+			}
+		}
+		nc.swept = cur
+	}
+	set := nc.buckets[b]
+	if _, ok := set[nonce]; ok {
+		return false
+	}
+	if set == nil {
+		set = make(map[string]struct{})
+		nc.buckets[b] = set
+	}
+	set[nonce] = struct{}{}
+	return true
+}
+
+// checkToken validates and spends the X-GoCaptcha-Token header, if present.
+// It returns the payload of a fresh token, a penalty and reason for an
+// expired or forged one, and block for a replayed one: a replay is a
+// captured token resubmitted by a script.
+func (c *Captcha) checkToken(r *http.Request, now time.Time) (p *tokenPayload, penalty int, reason string, block bool) {
+	tok := strings.TrimSpace(r.Header.Get(TokenHeader))
+	if tok == "" {
+		return nil, 0, "", false
+	}
+	p, err := c.parseToken(tok)
+	switch {
+	case errors.Is(err, errExpired):
+		return nil, -2, "expired_captcha_token", false
+	case err != nil:
+		return nil, -3, "bad_captcha_token", false
+	case !c.nonces.spend(p.Nonce, now):
+		return nil, 0, "replayed_captcha_token", true
+	}
+	return p, 0, "", false
+}
+
+// FormTokenField is the hidden input carrying the token from FormToken.
+const FormTokenField = "gc_form_token"
+
+// formTokenTTL is generous: forms may stay open for a long time.
+const formTokenTTL = 24 * time.Hour
+
+// FormToken returns a fresh signed token for one rendered form. The template
+// helpers and InjectHTML add it as the gc_form_token hidden input; CheckRequest
+// rejects forged, expired and reused tokens. Forms without the field are not
+// penalized, so hand-written templates keep working.
+func (c *Captcha) FormToken() string {
+	return c.issue("form", formTokenTTL)
+}
+
+// checkFormToken validates and spends the gc_form_token field, if present.
+// Like a replayed X-GoCaptcha-Token, a resubmitted form token blocks.
+func (c *Captcha) checkFormToken(r *http.Request, now time.Time) (penalty int, reason string, block bool) {
+	tok := strings.TrimSpace(r.PostFormValue(FormTokenField))
+	if tok == "" {
+		return 0, "", false
+	}
+	switch err := c.verify("form", tok); {
+	case errors.Is(err, errExpired):
+		return -2, "expired_form_token", false
+	case err != nil:
+		return -3, "bad_form_token", false
+	case !c.nonces.spend(tok, now):
+		return 0, "replayed_form_token", true
+	}
+	return 0, "", false
+}
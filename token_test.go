@@ -0,0 +1,53 @@
+package gocaptcha
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNonceCacheSpend(t *testing.T) {
+	var nc nonceCache
+	now := time.Unix(1700000000, 0)
+	soon := strconv.FormatInt(now.Add(time.Minute).Unix(), 10) + ".a.mac"
+	later := strconv.FormatInt(now.Add(time.Hour).Unix(), 10) + ".b.mac"
+
+	if !nc.spend(soon, now) || !nc.spend(later, now) {
+		t.Fatal("first spend rejected")
+	}
+	if nc.spend(soon, now) || nc.spend(later, now) {
+		t.Fatal("replay accepted")
+	}
+
+	// Two minutes on, the bucket of soon is swept and later's is kept
+	nc.spend(strconv.FormatInt(now.Add(2*time.Hour).Unix(), 10)+".c.mac", now.Add(2*time.Minute))
+	if len(nc.buckets) != 2 {
+		t.Errorf("%d buckets after sweep, want 2", len(nc.buckets))
+	}
+	if nc.spend(later, now.Add(2*time.Minute)) {
+		t.Error("replay accepted after sweep")
+	}
+}
+
+func TestReplayedFormTokenBlocks(t *testing.T) {
+	c := New(Config{})
+	tok := c.FormToken()
+	submit := func() (bool, string) {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{FormTokenField: {tok}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		_, why, block := c.checkFormToken(r, time.Now())
+		return block, why
+	}
+	if block, why := submit(); block || why != "" {
+		t.Fatalf("first submit = %v, %q, want no finding", block, why)
+	}
+	if block, why := submit(); !block || why != "replayed_form_token" {
+		t.Errorf("replay = %v, %q, want blocked as replayed_form_token", block, why)
+	}
+}
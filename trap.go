@@ -0,0 +1,177 @@
+package gocaptcha
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reputationStore remembers flagged clients by key "ip:<network>" (see
+// ipNetwork, so Config.IPv4Prefix and IPv6Prefix decide what one client is).
+// Entries are kept in memory and, when storage is enabled, written through
+// to the captcha_reputation table.
+type reputationStore struct {
+	mu sync.Mutex
+	m  map[string]reputationEntry
+}
+
+type reputationEntry struct {
+	reason  string
+	hits    int
+	expires time.Time
+}
+
+// initReputationStorage creates the reputation table and loads unexpired entries.
+func (c *Captcha) initReputationStorage() {
+	c.db.Exec(`CREATE TABLE IF NOT EXISTS captcha_reputation (
+		key TEXT PRIMARY KEY,
+		reason TEXT NOT NULL,
+		hits INTEGER NOT NULL DEFAULT 1,
+		expires INTEGER NOT NULL
+	)`)
+	now := time.Now().Unix()
+	c.db.Exec(`DELETE FROM captcha_reputation WHERE expires <= ?`, now)
+	// Older versions also flagged "fp:" keys (a /24 or /64 plus User-Agent hash),
+	// which caught everyone behind the same NAT with the same browser
+	c.db.Exec(`DELETE FROM captcha_reputation WHERE key LIKE 'fp:%'`)
+
+	rs := &c.reputation
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rows, err := c.db.Query(`SELECT key, reason, hits, expires FROM captcha_reputation`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, reason string
+		var hits int
+		var exp int64
+		if err := rows.Scan(&key, &reason, &hits, &exp); err == nil {
+			rs.m[key] = reputationEntry{reason: reason, hits: hits, expires: time.Unix(exp, 0)}
+		}
+	}
+}
+
+// reputationKey returns the store key for a request.
+func (c *Captcha) reputationKey(r *http.Request) string {
+	return "ip:" + c.ipNetwork(c.clientIP(r))
+}
+
+// Flag records the request's client (its network, see Config.IPv4Prefix and
+// IPv6Prefix) in the reputation store for Config.ReputationTTL. Later
+// CheckRequest calls from the same network lose Config.ReputationPenalty
+// points (reason "reputation:<reason>"). The trap link flags with reason
+// "trap"; call Flag yourself for other signals, e.g. probes of /wp-login.php.
+func (c *Captcha) Flag(r *http.Request, reason string) {
+	key := c.reputationKey(r)
+	exp := time.Now().Add(c.cfg.ReputationTTL)
+	rs := &c.reputation
+	rs.mu.Lock()
+	e := rs.m[key]
+	if time.Now().After(e.expires) {
+		e.hits = 0
+	}
+	e.reason, e.hits, e.expires = reason, e.hits+1, exp
+	rs.m[key] = e
+	rs.mu.Unlock()
+	if c.db != nil {
+		_, _ = c.db.Exec(`INSERT INTO captcha_reputation (key, reason, hits, expires) VALUES (?, ?, 1, ?)
+			ON CONFLICT(key) DO UPDATE SET reason = excluded.reason,
+				hits = CASE WHEN expires <= ? THEN 1 ELSE hits + 1 END, expires = excluded.expires`,
+			key, reason, exp.Unix(), time.Now().Unix())
+	}
+}
+
+// ClearReputation removes the entry of ip's network (see Config.IPv4Prefix
+// and IPv6Prefix), e.g. after a false positive.
+func (c *Captcha) ClearReputation(ip string) {
+	key := "ip:" + c.ipNetwork(ip)
+	rs := &c.reputation
+	rs.mu.Lock()
+	delete(rs.m, key)
+	rs.mu.Unlock()
+	if c.db != nil {
+		_, _ = c.db.Exec(`DELETE FROM captcha_reputation WHERE key = ?`, key)
+	}
+}
+
+// checkReputation returns the penalty for a flagged network.
+func (c *Captcha) checkReputation(r *http.Request) (int, string) {
+	key := c.reputationKey(r)
+	rs := &c.reputation
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	e, ok := rs.m[key]
+	if !ok {
+		return 0, ""
+	}
+	if time.Now().After(e.expires) {
+		delete(rs.m, key)
+		return 0, ""
+	}
+	return -c.cfg.ReputationPenalty, "reputation:" + e.reason
+}
+
+// TrapPath returns the URL of the trap link: Config.TrapPath or, by
+// default, an innocuous-looking name under EndpointPath derived from the
+// secret (served by Handler).
+func (c *Captcha) TrapPath() string {
+	if c.cfg.TrapPath != "" {
+		return c.cfg.TrapPath
+	}
+	sum := sha256.Sum256([]byte(c.sign("trap", "path")))
+	return c.endpoint("l" + hex.EncodeToString(sum[:5]))
+}
+
+// TrapHandler flags every client that requests it (see Flag) and answers
+// 404. Handler serves it at TrapPath(); mount it yourself when you set
+// Config.TrapPath outside EndpointPath. Verified crawlers (Config.GoodBots)
+// are not flagged.
+func (c *Captcha) TrapHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.isGoodBot(r) {
+			c.Flag(r, "trap")
+			c.log(c.clientIP(r), r.Header.Get("User-Agent"), -c.cfg.ReputationPenalty, []string{"trap_link"})
+		}
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		http.NotFound(w, r)
+	})
+}
+
+// TrapLinkHTML returns the hidden trap link. People never see or follow it
+// (hidden attribute, no tab stop), well-behaved crawlers skip it (nofollow
+// and the robots.txt Disallow from RobotsTxt), and scrapers that follow
+// every href get flagged. InjectHTML adds it before </body> when
+// Config.Traps is set.
+func (c *Captcha) TrapLinkHTML() string {
+	if !c.cfg.Traps {
+		return ""
+	}
+	return `<a href="` + html.EscapeString(c.TrapPath()) + `" hidden aria-hidden="true" tabindex="-1" rel="nofollow">Archive</a>`
+}
+
+// RobotsTxt serves robots.txt: rules (your existing content, may be empty)
+// followed by a group disallowing TrapPath() for all user agents. Crawlers
+// combine groups for the same user agent, so rules may have its own
+// "User-agent: *" group.
+//
+//	http.Handle("/robots.txt", cap.RobotsTxt("User-agent: *\nDisallow: /admin/\n"))
+func (c *Captcha) RobotsTxt(rules string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.TrimRight(rules, "\n")
+		if body != "" {
+			body += "\n\n"
+		}
+		body += "User-agent: *\nDisallow: " + c.TrapPath() + "\n"
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	})
+}